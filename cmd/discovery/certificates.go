@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/caddy"
+)
+
+// runCertificates prints the certificates Caddy serves for the hosts routed by the managed servers and fails
+// if a routed host has no valid certificate
+func runCertificates(args []string) error {
+	flags := flag.NewFlagSet("certificates", flag.ContinueOnError)
+	address := flags.String("address", "", "TLS listener of Caddy, derived from the listen addresses of the servers if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	caddyConfig, err := loadConfiguration()
+	if err != nil {
+		return err
+	}
+	if *address != "" {
+		caddyConfig.CertificateCheck.Address = *address
+	}
+	caddyConnector, err := caddy.NewConnector(caddyConfig)
+	if err != nil {
+		return err
+	}
+
+	config, err := caddyConnector.GetCaddyConfig()
+	if err != nil {
+		return err
+	}
+	serverHosts := make(map[string][]string)
+	for _, server := range caddyConfig.ManagedServers() {
+		serverHosts[server.Name] = config.Apps.HTTP.Servers[server.Name].Hosts()
+	}
+
+	statuses := caddyConnector.CheckCertificates(serverHosts)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "HOST\tADDRESS\tSTATUS\tISSUER\tEXPIRES\tERROR")
+	invalid := 0
+	for _, status := range statuses {
+		state := "valid"
+		if !status.Valid {
+			state = "INVALID"
+			invalid++
+		}
+		expires := ""
+		if !status.NotAfter.IsZero() {
+			expires = status.NotAfter.Format(time.RFC3339)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", status.Host, status.Address, state, status.Issuer, expires, status.Error)
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d routed hosts have no valid certificate", invalid, len(statuses))
+	}
+	return nil
+}
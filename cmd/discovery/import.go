@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/jaku01/caddyservicediscovery/internal/caddy"
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+	"go.yaml.in/yaml/v3"
+)
+
+// runImport prints the reverse proxy sites of an existing Caddy config as manual routes in the configuration.yaml format
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	jsonFile := flags.String("json", "", "path to a Caddy JSON config, defaults to the config of the running Caddy instance")
+	caddyfile := flags.String("caddyfile", "", "path to a Caddyfile, adapted to JSON by the running Caddy instance")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *jsonFile != "" && *caddyfile != "" {
+		return errors.New("only one of -json and -caddyfile can be used")
+	}
+
+	caddyConfig, err := loadConfiguration()
+	if err != nil {
+		return err
+	}
+	caddyConnector, err := caddy.NewConnector(caddyConfig)
+	if err != nil {
+		return err
+	}
+
+	var content []byte
+	switch {
+	case *jsonFile != "":
+		content, err = os.ReadFile(*jsonFile)
+	case *caddyfile != "":
+		var caddyfileContent []byte
+		caddyfileContent, err = os.ReadFile(*caddyfile)
+		if err != nil {
+			return err
+		}
+		content, err = caddyConnector.AdaptCaddyfile(caddyfileContent)
+	default:
+		content, err = caddyConnector.GetCaddyConfigJSON()
+	}
+	if err != nil {
+		return err
+	}
+
+	manualRoutes, issues, err := caddy.ImportManualRoutes(content)
+	if err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		slog.Warn("Skipped route that cannot be imported", "server", issue.Server, "hosts", issue.Hosts, "reason", issue.Reason)
+	}
+
+	var output struct {
+		ManualRoutes struct {
+			Routes []discovery.ManualRoute `yaml:"routes"`
+		} `yaml:"manualRoutes"`
+	}
+	output.ManualRoutes.Routes = manualRoutes
+
+	routesContent, err := yaml.Marshal(output)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(routesContent))
+
+	slog.Info("Import finished", "importedRoutes", len(manualRoutes), "skippedRoutes", len(issues))
+	return nil
+}
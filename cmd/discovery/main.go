@@ -2,8 +2,11 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"log/slog"
+	"net"
+	"os"
 
 	"github.com/jaku01/caddyservicediscovery/internal/caddy"
 	"github.com/jaku01/caddyservicediscovery/internal/discovery"
@@ -12,22 +15,60 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			slog.Error("Command failed", "command", os.Args[1], "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	caddyConfig, err := loadConfiguration()
 	if err != nil {
 		panic(err)
 	}
 	log.Println(caddyConfig.String())
-	slog.Info("Configuration: CaddyAdminUrl", "url", caddyConfig.CaddyAdminUrl)
+	slog.Info("Configuration: CaddyAdminUrl", "urls", caddyConfig.CaddyAdminUrls)
 
 	providerConnector, err := newServiceDiscoveryProviderConnector()
 	if err != nil {
 		panic(err)
 	}
 
-	caddyConnector := caddy.NewConnector(caddyConfig)
-	if err = manager.StartServiceDiscovery(caddyConnector, providerConnector); err != nil {
+	caddyConnectors, err := newCaddyConnectors(caddyConfig)
+	if err != nil {
 		panic(err)
 	}
+	if err = manager.StartServiceDiscovery(caddyConnectors, providerConnector); err != nil {
+		panic(err)
+	}
+}
+
+// newCaddyConnectors creates a connector for every configured Caddy admin url
+func newCaddyConnectors(caddyConfig discovery.CaddyConfig) ([]*caddy.Connector, error) {
+	caddyConnectors := make([]*caddy.Connector, 0, len(caddyConfig.CaddyAdminUrls))
+	for _, adminUrl := range caddyConfig.CaddyAdminUrls {
+		instanceConfig := caddyConfig
+		instanceConfig.CaddyAdminUrl = adminUrl
+
+		caddyConnector, err := caddy.NewConnector(instanceConfig)
+		if err != nil {
+			return nil, err
+		}
+		caddyConnectors = append(caddyConnectors, caddyConnector)
+	}
+	return caddyConnectors, nil
+}
+
+func runCommand(command string, args []string) error {
+	switch command {
+	case "import":
+		return runImport(args)
+	case "certificates":
+		return runCertificates(args)
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
 }
 
 func loadConfiguration() (discovery.CaddyConfig, error) {
@@ -42,6 +83,20 @@ func loadConfiguration() (discovery.CaddyConfig, error) {
 
 	viper.SetDefault("manualRoutes.routes", []map[string]interface{}{})
 
+	viper.SetDefault("retry.requestTimeout", "10s")
+	viper.SetDefault("retry.maxAttempts", 4)
+	viper.SetDefault("retry.initialBackoff", "500ms")
+	viper.SetDefault("retry.maxBackoff", "10s")
+	viper.SetDefault("retry.failureThreshold", 3)
+	viper.SetDefault("retry.openDuration", "30s")
+	viper.SetDefault("retry.reapplyInterval", "15s")
+
+	viper.SetDefault("watchdog.interval", "30s")
+
+	viper.SetDefault("maintenance.retryAfter", "60s")
+
+	viper.SetDefault("certificateCheck.timeout", "5s")
+
 	if err := viper.ReadInConfig(); err != nil {
 		var configFileNotFoundError viper.ConfigFileNotFoundError
 		if !errors.As(err, &configFileNotFoundError) {
@@ -52,10 +107,96 @@ func loadConfiguration() (discovery.CaddyConfig, error) {
 		slog.Info("Configuration file loaded successfully")
 	}
 
-	caddyAdminUrl := viper.GetString("CaddyAdminUrl")
+	// CaddyAdminUrl is either a single url or a list of urls for multiple Caddy instances
+	caddyAdminUrls := viper.GetStringSlice("CaddyAdminUrl")
+	if len(caddyAdminUrls) == 0 {
+		return discovery.CaddyConfig{}, errors.New("no CaddyAdminUrl configured")
+	}
 
 	caddyTlsConfig := getCaddyTlsConfig()
 
+	var adminConfig discovery.AdminConfig
+	if err := viper.UnmarshalKey("admin", &adminConfig); err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+
+	var retryConfig discovery.RetryConfig
+	if err := viper.UnmarshalKey("retry", &retryConfig); err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+
+	var watchdogConfig discovery.WatchdogConfig
+	if err := viper.UnmarshalKey("watchdog", &watchdogConfig); err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+
+	servers, err := getServers()
+	if err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+
+	var apiConfig discovery.ApiConfig
+	if err := viper.UnmarshalKey("api", &apiConfig); err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+
+	var headersConfig discovery.HeadersConfig
+	if err := viper.UnmarshalKey("headers", &headersConfig); err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+
+	var forwardAuthConfig discovery.ForwardAuthConfig
+	if err := viper.UnmarshalKey("forwardAuth", &forwardAuthConfig); err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+
+	var compressionConfig discovery.CompressionConfig
+	if err := viper.UnmarshalKey("compression", &compressionConfig); err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+
+	var fallback discovery.PageConfig
+	if err := viper.UnmarshalKey("fallback", &fallback); err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+	if err := fallback.Load(); err != nil {
+		return discovery.CaddyConfig{}, fmt.Errorf("could not read fallback page: %w", err)
+	}
+
+	var errorPages discovery.ErrorPagesConfig
+	if err := viper.UnmarshalKey("errorPages", &errorPages); err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+	if err := errorPages.Load(); err != nil {
+		return discovery.CaddyConfig{}, fmt.Errorf("could not read error page: %w", err)
+	}
+
+	var maintenance discovery.MaintenanceConfig
+	if err := viper.UnmarshalKey("maintenance", &maintenance); err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+	if err := maintenance.Load(); err != nil {
+		return discovery.CaddyConfig{}, fmt.Errorf("could not read maintenance page: %w", err)
+	}
+
+	trustedProxies := viper.GetStringSlice("trustedProxies")
+	if err := discovery.ValidateIPRanges(trustedProxies); err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+
+	var certificateCheckConfig discovery.CertificateCheckConfig
+	if err := viper.UnmarshalKey("certificateCheck", &certificateCheckConfig); err != nil {
+		return discovery.CaddyConfig{}, err
+	}
+
+	if caddyTlsConfig.OnDemand.Enabled && caddyTlsConfig.OnDemand.AskUrl == "" {
+		askUrl, err := defaultAskUrl(apiConfig.Listen)
+		if err != nil {
+			return discovery.CaddyConfig{}, err
+		}
+		caddyTlsConfig.OnDemand.AskUrl = askUrl
+	}
+
 	var manualRoutes []discovery.ManualRoute
 	if err := viper.UnmarshalKey("manualRoutes.routes", &manualRoutes); err != nil {
 		slog.Warn("Failed to unmarshal manual routes, using defaults", "error", err)
@@ -63,33 +204,87 @@ func loadConfiguration() (discovery.CaddyConfig, error) {
 	}
 
 	return discovery.CaddyConfig{
-		TLSConfig:     caddyTlsConfig,
-		CaddyAdminUrl: caddyAdminUrl,
-		ManualRoutes:  manualRoutes,
+		TLSConfig:        caddyTlsConfig,
+		CaddyAdminUrl:    caddyAdminUrls[0],
+		CaddyAdminUrls:   caddyAdminUrls,
+		ManualRoutes:     manualRoutes,
+		Admin:            adminConfig,
+		Retry:            retryConfig,
+		Watchdog:         watchdogConfig,
+		Servers:          servers,
+		Api:              apiConfig,
+		Headers:          headersConfig,
+		ForwardAuth:      forwardAuthConfig,
+		Compression:      compressionConfig,
+		CacheControl:     viper.GetString("cacheControl"),
+		Fallback:         fallback,
+		ErrorPages:       errorPages,
+		Maintenance:      maintenance,
+		TrustedProxies:   trustedProxies,
+		CertificateCheck: certificateCheckConfig,
 	}, nil
 }
 
+func getServers() ([]discovery.ServerConfig, error) {
+	var servers []discovery.ServerConfig
+	if err := viper.UnmarshalKey("servers", &servers); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		if server.Name == "" {
+			return nil, errors.New("server without name configured")
+		}
+		if names[server.Name] {
+			return nil, fmt.Errorf("server %s configured more than once", server.Name)
+		}
+		if len(server.Listen) == 0 {
+			return nil, fmt.Errorf("server %s has no listen addresses", server.Name)
+		}
+		names[server.Name] = true
+	}
+	return servers, nil
+}
+
+// defaultAskUrl derives the url of the on-demand TLS ask endpoint from the api listen address
+func defaultAskUrl(apiListen string) (string, error) {
+	if apiListen == "" {
+		return "", errors.New("on-demand TLS requires the api to be enabled with api.listen")
+	}
+
+	host, port, err := net.SplitHostPort(apiListen)
+	if err != nil {
+		return "", fmt.Errorf("invalid api listen address %s: %w", apiListen, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/ask", nil
+}
+
 func getCaddyTlsConfig() discovery.TLSConfig {
 	var tlsConfig discovery.TLSConfig
-	useDefaults := false
-
 	if err := viper.UnmarshalKey("tls", &tlsConfig); err != nil {
 		slog.Warn("[TLS-Config] Failed to unmarshal TLS config", "error", err)
-		useDefaults = true
+		return discovery.TLSConfig{}
 	}
 
+	useManualDefaults := false
 	if tlsConfig.CertFilePath == "" {
 		slog.Warn("[TLS-Config] No cert file path specified")
-		useDefaults = true
+		useManualDefaults = true
 	}
 
 	if tlsConfig.KeyFilePath == "" {
 		slog.Warn("[TLS-Config] No key file path specified")
-		useDefaults = true
+		useManualDefaults = true
 	}
 
-	if useDefaults {
-		return discovery.TLSConfig{}
+	if useManualDefaults {
+		tlsConfig.Manual = false
+		tlsConfig.CertFilePath = ""
+		tlsConfig.KeyFilePath = ""
 	}
 	return tlsConfig
 }
@@ -0,0 +1,43 @@
+package caddy
+
+import (
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// newAccessDeniedRoute creates a subroute answering requests of denied or not allowed clients with 403,
+// nil if the route has no access restrictions
+func newAccessDeniedRoute(access discovery.AccessConfig) *Route {
+	if !access.IsRestricted() {
+		return nil
+	}
+
+	var match []Match
+	if len(access.Deny) > 0 {
+		match = append(match, Match{ClientIP: &IPMatch{Ranges: access.Deny}})
+	}
+	if len(access.Allow) > 0 {
+		match = append(match, Match{Not: []Match{{ClientIP: &IPMatch{Ranges: access.Allow}}}})
+	}
+
+	return &Route{
+		Match: match,
+		Handle: []Handle{
+			{
+				Handler:    "static_response",
+				StatusCode: 403,
+				Body:       "Forbidden",
+			},
+		},
+	}
+}
+
+// NewTrustedProxies creates the trusted proxies of a server, nil if no proxies are trusted
+func NewTrustedProxies(ranges []string) *TrustedProxies {
+	if len(ranges) == 0 {
+		return nil
+	}
+	return &TrustedProxies{
+		Source: "static",
+		Ranges: ranges,
+	}
+}
@@ -0,0 +1,43 @@
+package caddy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+func TestNewServiceRouteDeniesClientsBeforeProxy(t *testing.T) {
+	route := NewServiceRoute("admin.example.com", ":9000", discovery.RouteOptions{
+		Access: discovery.AccessConfig{
+			Allow: []string{"10.8.0.0/16"},
+			Deny:  []string{"10.8.0.66"},
+		},
+	})
+
+	subroutes := route.Handle[0].Routes
+	if len(subroutes) != 2 {
+		t.Fatalf("Expected access denied route before the proxy route, got %d subroutes", len(subroutes))
+	}
+
+	content, err := json.Marshal(subroutes[0])
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"match":[{"client_ip":{"ranges":["10.8.0.66"]}},{"not":[{"client_ip":{"ranges":["10.8.0.0/16"]}}]}],` +
+		`"handle":[{"handler":"static_response","status_code":403,"body":"Forbidden"}]}`
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+	if subroutes[1].Handle[0].Handler != "reverse_proxy" {
+		t.Errorf("Expected reverse_proxy after the access check, got %s", subroutes[1].Handle[0].Handler)
+	}
+}
+
+func TestNewServiceRouteWithoutAccessRestrictions(t *testing.T) {
+	route := NewServiceRoute("app.example.com", ":8080", discovery.RouteOptions{})
+
+	if subroutes := route.Handle[0].Routes; len(subroutes) != 1 {
+		t.Errorf("Expected only the proxy route, got %d subroutes", len(subroutes))
+	}
+}
@@ -1,6 +1,10 @@
 package caddy
 
-import "encoding/json"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
 
 func UnmarshalCaddyConfig(data []byte) (Config, error) {
 	var r Config
@@ -22,6 +26,25 @@ type Server struct {
 	Listen                []string              `json:"listen"`
 	Routes                []Route               `json:"routes"`
 	TLSConnectionPolicies []TLSConnectionPolicy `json:"tls_connection_policies,omitempty"`
+	AutomaticHTTPS        *AutomaticHTTPS       `json:"automatic_https,omitempty"`
+	TrustedProxies        *TrustedProxies       `json:"trusted_proxies,omitempty"`
+	Errors                *ServerErrors         `json:"errors,omitempty"`
+}
+
+// ServerErrors holds the routes handling errors of a server
+type ServerErrors struct {
+	Routes []Route `json:"routes"`
+}
+
+// TrustedProxies are the proxies whose forwarded client address headers are used as client ip
+type TrustedProxies struct {
+	Source string   `json:"source"`
+	Ranges []string `json:"ranges,omitempty"`
+}
+
+type AutomaticHTTPS struct {
+	// PreferWildcard serves managed wildcard certificates instead of obtaining certificates per host
+	PreferWildcard bool `json:"prefer_wildcard,omitempty"`
 }
 
 type Route struct {
@@ -31,6 +54,27 @@ type Route struct {
 
 type Match struct {
 	Host []string `json:"host,omitempty"`
+	Path []string `json:"path,omitempty"`
+	// Header matches request headers, values may use * as prefix or suffix wildcard
+	Header map[string][]string `json:"header,omitempty"`
+	// ClientIP matches the client address, which respects the trusted proxies of the server
+	ClientIP *IPMatch `json:"client_ip,omitempty"`
+	// RemoteIP matches the address of the direct peer of the connection
+	RemoteIP *IPMatch   `json:"remote_ip,omitempty"`
+	Not      []Match    `json:"not,omitempty"`
+	File     *FileMatch `json:"file,omitempty"`
+	// Expression is a CEL expression, e.g. matching error status codes in error routes
+	Expression string `json:"expression,omitempty"`
+}
+
+// FileMatch matches requests whose first existing file of TryFiles is found below Root
+type FileMatch struct {
+	Root     string   `json:"root,omitempty"`
+	TryFiles []string `json:"try_files,omitempty"`
+}
+
+type IPMatch struct {
+	Ranges []string `json:"ranges"`
 }
 
 type Handle struct {
@@ -44,6 +88,106 @@ type Handle struct {
 
 	// optional transport configuration for reverse_proxy upstreams
 	Transport *Transport `json:"transport,omitempty"`
+	// optional upstream selection of reverse_proxy handles with several upstreams
+	LoadBalancing *LoadBalancing `json:"load_balancing,omitempty"`
+	// optional reverse_proxy settings used for forward auth
+	Headers        *ProxyHeaders     `json:"headers,omitempty"`
+	Rewrite        *Rewrite          `json:"rewrite,omitempty"`
+	HandleResponse []ResponseHandler `json:"handle_response,omitempty"`
+
+	// for headers
+	Request  *HeaderOps         `json:"request,omitempty"`
+	Response *ResponseHeaderOps `json:"response,omitempty"`
+
+	// for authentication
+	Providers *AuthProviders `json:"providers,omitempty"`
+
+	// for file_server
+	Root       string   `json:"root,omitempty"`
+	IndexNames []string `json:"index_names,omitempty"`
+
+	// for rewrite
+	URI string `json:"uri,omitempty"`
+
+	// for encode
+	Encodings     map[string]struct{} `json:"encodings,omitempty"`
+	Prefer        []string            `json:"prefer,omitempty"`
+	MinimumLength int                 `json:"minimum_length,omitempty"`
+	Match         *ResponseMatch      `json:"match,omitempty"`
+}
+
+type AuthProviders struct {
+	HTTPBasic *HTTPBasicAuth `json:"http_basic,omitempty"`
+}
+
+type HTTPBasicAuth struct {
+	Accounts []Account      `json:"accounts,omitempty"`
+	Hash     *HashAlgorithm `json:"hash,omitempty"`
+	Realm    string         `json:"realm,omitempty"`
+}
+
+type Account struct {
+	Username string `json:"username"`
+	// Password is the bcrypt hash of the password
+	Password string `json:"password"`
+}
+
+type HashAlgorithm struct {
+	Algorithm string `json:"algorithm"`
+}
+
+type HeaderOps struct {
+	Set    map[string][]string `json:"set,omitempty"`
+	Add    map[string][]string `json:"add,omitempty"`
+	Delete []string            `json:"delete,omitempty"`
+}
+
+// ProxyHeaders are the header operations of a reverse_proxy
+type ProxyHeaders struct {
+	Request *HeaderOps `json:"request,omitempty"`
+}
+
+// Rewrite changes the request a reverse_proxy sends to its upstream
+type Rewrite struct {
+	Method string `json:"method,omitempty"`
+	URI    string `json:"uri,omitempty"`
+}
+
+// ResponseHandler handles upstream responses of a reverse_proxy matching its status codes
+type ResponseHandler struct {
+	Match  *ResponseMatch `json:"match,omitempty"`
+	Routes []Route        `json:"routes,omitempty"`
+}
+
+type ResponseMatch struct {
+	// StatusCode matches status classes like 2 for 2xx or exact status codes
+	StatusCode []int `json:"status_code,omitempty"`
+	// Headers matches response headers, values may use * as prefix or suffix wildcard
+	Headers map[string][]string `json:"headers,omitempty"`
+}
+
+type ResponseHeaderOps struct {
+	HeaderOps
+	// Deferred applies the operations when the response is written, after the upstream set its headers
+	Deferred bool `json:"deferred,omitempty"`
+}
+
+type LoadBalancing struct {
+	SelectionPolicy *SelectionPolicy `json:"selection_policy,omitempty"`
+}
+
+// SelectionPolicy selects the upstream of a request
+type SelectionPolicy struct {
+	Policy string `json:"policy"`
+	// Weights are the weights of the upstreams in order for the weighted_round_robin policy
+	Weights []int `json:"weights,omitempty"`
+	// Name and Secret are the cookie name and the secret signing its value for the cookie policy
+	Name   string `json:"name,omitempty"`
+	Secret string `json:"secret,omitempty"`
+	// Field is the request header selecting the upstream for the header policy
+	Field string `json:"field,omitempty"`
+	// Fallback selects the upstream if the cookie or header policy can not, e.g. for new clients
+	Fallback *SelectionPolicy `json:"fallback,omitempty"`
 }
 
 type Upstream struct {
@@ -51,26 +195,130 @@ type Upstream struct {
 }
 
 type Transport struct {
-	Protocol string        `json:"protocol,omitempty"`
-	TLS      *TransportTLS `json:"tls,omitempty"`
+	Protocol              string        `json:"protocol,omitempty"`
+	TLS                   *TransportTLS `json:"tls,omitempty"`
+	DialTimeout           string        `json:"dial_timeout,omitempty"`
+	ResponseHeaderTimeout string        `json:"response_header_timeout,omitempty"`
+	ReadTimeout           string        `json:"read_timeout,omitempty"`
+	WriteTimeout          string        `json:"write_timeout,omitempty"`
+	KeepAlive             *KeepAlive    `json:"keep_alive,omitempty"`
+	Versions              []string      `json:"versions,omitempty"`
+	MaxConnsPerHost       int           `json:"max_conns_per_host,omitempty"`
+}
+
+type KeepAlive struct {
+	Enabled             *bool  `json:"enabled,omitempty"`
+	IdleTimeout         string `json:"idle_timeout,omitempty"`
+	MaxIdleConnsPerHost int    `json:"max_idle_conns_per_host,omitempty"`
 }
 
 type TransportTLS struct {
-	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+	ServerName               string   `json:"server_name,omitempty"`
+	RootCAPEMFiles           []string `json:"root_ca_pem_files,omitempty"`
+	ClientCertificateFile    string   `json:"client_certificate_file,omitempty"`
+	ClientCertificateKeyFile string   `json:"client_certificate_key_file,omitempty"`
+	InsecureSkipVerify       bool     `json:"insecure_skip_verify,omitempty"`
 }
 
 type TLSConnectionPolicy struct {
+	Match                *TLSConnectionMatch   `json:"match,omitempty"`
+	CertificateSelection *CertificateSelection `json:"certificate_selection,omitempty"`
+}
+
+type TLSConnectionMatch struct {
+	SNI []string `json:"sni,omitempty"`
+}
+
+type CertificateSelection struct {
+	AnyTag []string `json:"any_tag,omitempty"`
 }
 
 type TLSApp struct {
-	Certificates Certificates `json:"certificates"`
+	Certificates Certificates   `json:"certificates"`
+	Automation   *TLSAutomation `json:"automation,omitempty"`
+}
+
+type TLSAutomation struct {
+	Policies []AutomationPolicy `json:"policies,omitempty"`
+	OnDemand *OnDemand          `json:"on_demand,omitempty"`
+}
+
+type AutomationPolicy struct {
+	Subjects []string `json:"subjects,omitempty"`
+	Issuers  []Issuer `json:"issuers,omitempty"`
+	OnDemand bool     `json:"on_demand,omitempty"`
+}
+
+type OnDemand struct {
+	Permission *OnDemandPermission `json:"permission,omitempty"`
+}
+
+type OnDemandPermission struct {
+	Module   string `json:"module"`
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+type Issuer struct {
+	Module string `json:"module"`
+
+	// for acme
+	CA         string            `json:"ca,omitempty"`
+	Email      string            `json:"email,omitempty"`
+	Challenges *IssuerChallenges `json:"challenges,omitempty"`
+}
+
+type IssuerChallenges struct {
+	DNS *DNSChallenge `json:"dns,omitempty"`
+}
+
+type DNSChallenge struct {
+	// Provider holds the name of the dns provider module and its settings
+	Provider  map[string]string `json:"provider,omitempty"`
+	Resolvers []string          `json:"resolvers,omitempty"`
 }
 
 type Certificates struct {
-	LoadFiles []LoadFile `json:"load_files,omitempty"`
+	LoadFiles   []LoadFile `json:"load_files,omitempty"`
+	LoadFolders []string   `json:"load_folders,omitempty"`
+	// Automate lists names that are managed even if no route matches them, e.g. wildcard names
+	Automate []string `json:"automate,omitempty"`
 }
 
 type LoadFile struct {
-	Certificate string `json:"certificate"`
-	Key         string `json:"key"`
+	Certificate string   `json:"certificate"`
+	Key         string   `json:"key"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// Hosts returns the hosts matched by the top level routes of the server
+func (s Server) Hosts() []string {
+	var hosts []string
+	for _, route := range s.Routes {
+		for _, match := range route.Match {
+			hosts = append(hosts, match.Host...)
+		}
+	}
+	return hosts
+}
+
+// Fingerprint returns a hash of a config value (e.g. routes or a server) that is stable between
+// the value sent to Caddy and the value read back from Caddy's config
+func Fingerprint[T any](value T) (string, error) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	// normalize the value by a round trip, so fields unknown to this package are dropped on both sides
+	var normalized T
+	if err = json.Unmarshal(content, &normalized); err != nil {
+		return "", err
+	}
+	content, err = json.Marshal(normalized)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
 }
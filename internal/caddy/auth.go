@@ -0,0 +1,90 @@
+package caddy
+
+import (
+	"sort"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// newBasicAuthHandle creates an authentication handler for the resolved basic auth accounts,
+// nil if the route is not protected
+func newBasicAuthHandle(basicAuth discovery.BasicAuthConfig) *Handle {
+	if len(basicAuth.Users) == 0 {
+		return nil
+	}
+
+	users := make([]string, 0, len(basicAuth.Users))
+	for user := range basicAuth.Users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	accounts := make([]Account, 0, len(users))
+	for _, user := range users {
+		accounts = append(accounts, Account{Username: user, Password: basicAuth.Users[user]})
+	}
+
+	return &Handle{
+		Handler: "authentication",
+		Providers: &AuthProviders{
+			HTTPBasic: &HTTPBasicAuth{
+				Accounts: accounts,
+				Hash:     &HashAlgorithm{Algorithm: "bcrypt"},
+				Realm:    basicAuth.Realm,
+			},
+		},
+	}
+}
+
+// newForwardAuthHandle creates a reverse proxy asking the auth service whether a request is allowed, nil if
+// forward auth is disabled. On 2xx responses the identity headers are copied to the request and the request
+// continues to the upstream, all other responses like redirects to the login page are sent to the client.
+func newForwardAuthHandle(forwardAuth discovery.ForwardAuthConfig) *Handle {
+	if !forwardAuth.IsEnabled() {
+		return nil
+	}
+
+	var responseRoutes []Route
+	if len(forwardAuth.CopyHeaders) > 0 {
+		// setting the headers also replaces identity headers sent by the client
+		copyHeaders := make(map[string][]string, len(forwardAuth.CopyHeaders))
+		for _, header := range forwardAuth.CopyHeaders {
+			copyHeaders[header] = []string{"{http.reverse_proxy.header." + header + "}"}
+		}
+		responseRoutes = append(responseRoutes, Route{
+			Handle: []Handle{
+				{
+					Handler: "headers",
+					Request: &HeaderOps{Set: copyHeaders},
+				},
+			},
+		})
+	}
+
+	return &Handle{
+		Handler: "reverse_proxy",
+		Upstreams: []Upstream{
+			{
+				Dial: forwardAuth.Address,
+			},
+		},
+		Rewrite: &Rewrite{
+			Method: "GET",
+			URI:    forwardAuth.Uri,
+		},
+		Headers: &ProxyHeaders{
+			Request: &HeaderOps{
+				Set: map[string][]string{
+					"X-Forwarded-Method": {"{http.request.method}"},
+					"X-Forwarded-Uri":    {"{http.request.uri}"},
+				},
+			},
+		},
+		HandleResponse: []ResponseHandler{
+			{
+				Match:  &ResponseMatch{StatusCode: []int{2}},
+				Routes: responseRoutes,
+			},
+		},
+	}
+}
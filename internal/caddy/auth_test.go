@@ -0,0 +1,63 @@
+package caddy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+func TestNewServiceRouteAddsBasicAuthBeforeProxy(t *testing.T) {
+	route := NewServiceRoute("dashboard.example.com", ":3000", discovery.RouteOptions{
+		BasicAuth: discovery.BasicAuthConfig{
+			Users: map[string]string{"bob": "$2a$14$bob", "alice": "$2a$14$alice"},
+			Realm: "dashboard",
+		},
+	})
+
+	handles := route.Handle[0].Routes[0].Handle
+	if len(handles) != 2 || handles[0].Handler != "authentication" || handles[1].Handler != "reverse_proxy" {
+		t.Fatalf("Expected authentication before reverse_proxy, got %+v", handles)
+	}
+
+	content, err := json.Marshal(handles[0])
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"handler":"authentication","providers":{"http_basic":{"accounts":[` +
+		`{"username":"alice","password":"$2a$14$alice"},{"username":"bob","password":"$2a$14$bob"}],` +
+		`"hash":{"algorithm":"bcrypt"},"realm":"dashboard"}}}`
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+}
+
+func TestNewServiceRouteAddsForwardAuthBeforeProxy(t *testing.T) {
+	enabled := true
+	route := NewServiceRoute("app.example.com", ":8080", discovery.RouteOptions{
+		ForwardAuth: discovery.ForwardAuthConfig{
+			Enabled:     &enabled,
+			Address:     "authelia:9091",
+			Uri:         "/api/verify?rd=https://auth.example.com",
+			CopyHeaders: []string{"Remote-User"},
+		},
+	})
+
+	handles := route.Handle[0].Routes[0].Handle
+	if len(handles) != 2 || handles[0].Handler != "reverse_proxy" || handles[1].Upstreams[0].Dial != ":8080" {
+		t.Fatalf("Expected forward auth before the upstream proxy, got %+v", handles)
+	}
+
+	content, err := json.Marshal(handles[0])
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"handler":"reverse_proxy","upstreams":[{"dial":"authelia:9091"}],` +
+		`"headers":{"request":{"set":{"X-Forwarded-Method":["{http.request.method}"],"X-Forwarded-Uri":["{http.request.uri}"]}}},` +
+		`"rewrite":{"method":"GET","uri":"/api/verify?rd=https://auth.example.com"},` +
+		`"handle_response":[{"match":{"status_code":[2]},"routes":[{"handle":[{"handler":"headers",` +
+		`"request":{"set":{"Remote-User":["{http.reverse_proxy.header.Remote-User}"]}}}]}]}]}`
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+}
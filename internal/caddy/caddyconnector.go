@@ -1,128 +1,147 @@
 package caddy
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 
 	"github.com/jaku01/caddyservicediscovery/internal/discovery"
 )
 
+// ErrNoCaddyConfig is returned if Caddy answered but has no config, e.g. after a restart without --resume
+var ErrNoCaddyConfig = errors.New("no caddy Config found")
+
 type Connector struct {
 	Config *discovery.CaddyConfig
+
+	client  *http.Client
+	baseUrl string
+	breaker *circuitBreaker
 }
 
-func NewConnector(caddyConfig discovery.CaddyConfig) *Connector {
-	return &Connector{
-		Config: &caddyConfig,
+func NewConnector(caddyConfig discovery.CaddyConfig) (*Connector, error) {
+	client, baseUrl, err := newAdminClient(caddyConfig)
+	if err != nil {
+		return nil, err
 	}
+
+	return &Connector{
+		Config:  &caddyConfig,
+		client:  client,
+		baseUrl: baseUrl,
+		breaker: newCircuitBreaker(caddyConfig.Retry.FailureThreshold, caddyConfig.Retry.OpenDuration),
+	}, nil
 }
 
 func (c *Connector) GetCaddyConfig() (*Config, error) {
-	url := c.Config.CaddyAdminUrl + "/config/"
-	resp, err := http.Get(url)
+	responseContent, err := c.GetCaddyConfigJSON()
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("request to %s failed with status code %d", url, resp.StatusCode)
+	caddyConfig, err := UnmarshalCaddyConfig(responseContent)
+	if err != nil {
+		return nil, err
 	}
+	return &caddyConfig, nil
+}
 
-	responseContent, err := io.ReadAll(resp.Body)
+// GetCaddyConfigJSON returns the config of the Caddy instance as JSON, including settings unknown to this package
+func (c *Connector) GetCaddyConfigJSON() ([]byte, error) {
+	responseContent, err := c.do(http.MethodGet, "/config/", "", nil)
 	if err != nil {
 		return nil, err
 	}
 
-	// if the content is "null", return nil
+	// if the content is "null", there is no config
 	if len(responseContent) == 0 || string(responseContent) == "null\n" {
-		return nil, fmt.Errorf("no caddy Config found")
+		return nil, ErrNoCaddyConfig
 	}
+	return responseContent, nil
+}
 
-	caddyConfig, err := UnmarshalCaddyConfig(responseContent)
+// AdaptCaddyfile converts a Caddyfile into a Caddy JSON config using the adapt endpoint of the admin api
+func (c *Connector) AdaptCaddyfile(caddyfile []byte) ([]byte, error) {
+	responseContent, err := c.do(http.MethodPost, "/adapt", "text/caddyfile", caddyfile)
 	if err != nil {
 		return nil, err
 	}
-	return &caddyConfig, nil
-}
 
-func (c *Connector) CreateCaddyConfig() error {
-	config := Config{}
-	config.Apps.HTTP.Servers = make(map[string]Server, 1)
-
-	server := Server{
-		Listen: []string{":443", ":80"},
-		Routes: []Route{},
+	var adapted struct {
+		Result   json.RawMessage `json:"result"`
+		Warnings []struct {
+			File    string `json:"file"`
+			Line    int    `json:"line"`
+			Message string `json:"message"`
+		} `json:"warnings"`
+	}
+	if err := json.Unmarshal(responseContent, &adapted); err != nil {
+		return nil, err
 	}
 
-	config.Apps.HTTP.Servers["srv0"] = server
+	for _, warning := range adapted.Warnings {
+		slog.Warn("Caddyfile adapter warning", "file", warning.File, "line", warning.Line, "message", warning.Message)
+	}
+	return adapted.Result, nil
+}
 
-	if c.Config.TLSConfig.Manual {
-		slog.Info("Using manual TLS configuration",
-			"certFilePath", c.Config.TLSConfig.CertFilePath,
-			"keyFilePath", c.Config.TLSConfig.KeyFilePath)
+func (c *Connector) CreateCaddyConfig() error {
+	config := Config{}
+	managedServers := c.Config.ManagedServers()
+	config.Apps.HTTP.Servers = make(map[string]Server, len(managedServers))
 
-		config.Apps.TLS = &TLSApp{
-			Certificates: Certificates{
-				LoadFiles: []LoadFile{
-					{
-						Certificate: c.Config.TLSConfig.CertFilePath,
-						Key:         c.Config.TLSConfig.KeyFilePath,
-					},
-				},
-			},
+	for _, managedServer := range managedServers {
+		config.Apps.HTTP.Servers[managedServer.Name] = Server{
+			Listen: managedServer.Listen,
+			Routes: []Route{},
 		}
 	}
 
-	url := c.Config.CaddyAdminUrl + "/load"
+	config.Apps.TLS = newTLSApp(c.Config.TLSConfig)
+
 	bodyContent, err := json.Marshal(config)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewReader(bodyContent))
-	if err != nil {
+	if _, err = c.do(http.MethodPost, "/load", "application/json", bodyContent); err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("request to %s failed with status code %d", url, resp.StatusCode)
-	}
 
 	slog.Info("Created Caddy config successfully")
 	return nil
 }
 
+// SetRoutes replaces the routes of the default server
 func (c *Connector) SetRoutes(routes []Route) error {
+	return c.SetServerRoutes(c.Config.DefaultServer(), routes)
+}
+
+// SetServerRoutes replaces the routes of the given server
+func (c *Connector) SetServerRoutes(serverName string, routes []Route) error {
 	reqBody, err := json.Marshal(routes)
 	if err != nil {
 		return err
 	}
 
-	url := c.Config.CaddyAdminUrl + "/config/apps/http/servers/srv0/routes/"
-	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewReader(reqBody))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
+	path := "/config/apps/http/servers/" + url.PathEscape(serverName) + "/routes/"
+	_, err = c.do(http.MethodPatch, path, "application/json", reqBody)
+	return err
+}
 
-	resp, err := http.DefaultClient.Do(req)
+// SetServer replaces the given server including its routes and TLS connection policies
+func (c *Connector) SetServer(serverName string, server Server) error {
+	reqBody, err := json.Marshal(server)
 	if err != nil {
 		return err
 	}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
-	}
-
-	defer resp.Body.Close()
-
-	return nil
+	path := "/config/apps/http/servers/" + url.PathEscape(serverName)
+	_, err = c.do(http.MethodPatch, path, "application/json", reqBody)
+	return err
 }
 
 // NewReverseProxyRoute creates a reverse proxy forwarding accesses to incomingDomain to upstreamPort
@@ -156,40 +175,58 @@ func NewReverseProxyRoute(incomingDomain string, upstreamAddr string) Route {
 	}
 }
 
-func NewExternalReverseProxyRoute(incomingDomain string, upstream string, tls bool) Route {
-	upstreamHandle := Handle{
-		Handler: "reverse_proxy",
-		Upstreams: []Upstream{
-			{
-				Dial: upstream,
-			},
-		},
+// NewExternalReverseProxyRoute creates a reverse proxy forwarding accesses to incomingDomain to the upstream,
+// connecting via TLS if upstreamTLS is enabled
+func NewExternalReverseProxyRoute(incomingDomain string, upstream string, upstreamTLS discovery.UpstreamTLSConfig) Route {
+	return NewServiceRoute(incomingDomain, upstream, discovery.RouteOptions{UpstreamTLS: upstreamTLS})
+}
+
+// NewServiceRoute creates a reverse proxy forwarding accesses to incomingDomain to the upstream
+// configured by the route options. Basic auth accounts have to be resolved already.
+func NewServiceRoute(incomingDomain string, upstream string, options discovery.RouteOptions) Route {
+	return newRoute(incomingDomain, options, []Handle{newProxyHandle([]string{upstream}, nil, options)})
+}
+
+// newRoute creates the route of a domain whose subroute applies the access restrictions, header operations,
+// compression and authentication of the route options before the handles and the following routes serving the request
+func newRoute(domain string, options discovery.RouteOptions, handles []Handle, routes ...Route) Route {
+	var chain []Handle
+	if headersHandle := newHeadersHandle(options.Headers); headersHandle != nil {
+		chain = append(chain, *headersHandle)
+	}
+	if encodeHandle := newEncodeHandle(options.Compression); encodeHandle != nil {
+		chain = append(chain, *encodeHandle)
+	}
+	if basicAuthHandle := newBasicAuthHandle(options.BasicAuth); basicAuthHandle != nil {
+		chain = append(chain, *basicAuthHandle)
 	}
+	if forwardAuthHandle := newForwardAuthHandle(options.ForwardAuth); forwardAuthHandle != nil {
+		chain = append(chain, *forwardAuthHandle)
+	}
+	chain = append(chain, handles...)
 
-	if tls {
-		upstreamHandle.Transport = &Transport{
-			Protocol: "http",
-			TLS:      &TransportTLS{},
-		}
+	var subroutes []Route
+	if accessDeniedRoute := newAccessDeniedRoute(options.Access); accessDeniedRoute != nil {
+		subroutes = append(subroutes, *accessDeniedRoute)
 	}
+	if len(chain) > 0 {
+		subroutes = append(subroutes, Route{
+			Match:  nil,
+			Handle: chain,
+		})
+	}
+	subroutes = append(subroutes, routes...)
 
 	return Route{
 		Handle: []Handle{
 			{
 				Handler: "subroute",
-				Routes: []Route{
-					{
-						Match: nil,
-						Handle: []Handle{
-							upstreamHandle,
-						},
-					},
-				},
+				Routes:  subroutes,
 			},
 		},
 		Match: []Match{
 			{
-				Host: []string{incomingDomain},
+				Host: []string{domain},
 			},
 		},
 	}
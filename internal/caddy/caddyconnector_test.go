@@ -1,10 +1,17 @@
 package caddy
 
 import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/jaku01/caddyservicediscovery/internal/discovery"
 )
@@ -24,7 +31,10 @@ func TestConnector_GetCaddyConfig(t *testing.T) {
 	}))
 
 	caddyConfig := discovery.CaddyConfig{CaddyAdminUrl: mockServer.URL}
-	connector := NewConnector(caddyConfig)
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
 	config, err := connector.GetCaddyConfig()
 	if err != nil {
@@ -57,9 +67,12 @@ func TestConnector_GetCaddyConfig(t *testing.T) {
 
 func TestConnector_GetCaddyConfigFailsBecauseOfInvalidUrl(t *testing.T) {
 	caddyConfig := discovery.CaddyConfig{CaddyAdminUrl: "invalid-url"}
-	connector := NewConnector(caddyConfig)
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
-	_, err := connector.GetCaddyConfig()
+	_, err = connector.GetCaddyConfig()
 	if err == nil {
 		t.Errorf("Expected error, got none")
 	}
@@ -80,8 +93,11 @@ func TestConnector_GetCaddyConfigFailsBecauseOfEmptyBody(t *testing.T) {
 	}))
 
 	caddyConfig := discovery.CaddyConfig{CaddyAdminUrl: mockServer.URL}
-	connector := NewConnector(caddyConfig)
-	_, err := connector.GetCaddyConfig()
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = connector.GetCaddyConfig()
 	if err == nil {
 		t.Errorf("Expected error, got none")
 	}
@@ -102,8 +118,11 @@ func TestConnector_GetCaddyConfigFailsBecauseOfInvalidJson(t *testing.T) {
 	}))
 
 	caddyConfig := discovery.CaddyConfig{CaddyAdminUrl: mockServer.URL}
-	connector := NewConnector(caddyConfig)
-	_, err := connector.GetCaddyConfig()
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = connector.GetCaddyConfig()
 	if err == nil {
 		t.Errorf("Expected error, got none")
 	}
@@ -120,8 +139,11 @@ func TestConnector_CreateCaddyConfig(t *testing.T) {
 	}))
 
 	caddyConfig := discovery.CaddyConfig{CaddyAdminUrl: mockServer.URL}
-	connector := NewConnector(caddyConfig)
-	err := connector.CreateCaddyConfig()
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	err = connector.CreateCaddyConfig()
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -129,9 +151,12 @@ func TestConnector_CreateCaddyConfig(t *testing.T) {
 
 func TestConnector_CreateCaddyConfigReturnsError(t *testing.T) {
 	caddyConfig := discovery.CaddyConfig{CaddyAdminUrl: "invalid-url"}
-	connector := NewConnector(caddyConfig)
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
-	err := connector.CreateCaddyConfig()
+	err = connector.CreateCaddyConfig()
 	if err == nil {
 		t.Errorf("Expected error, got none")
 	}
@@ -148,12 +173,15 @@ func TestConnector_ReplaceRoutes(t *testing.T) {
 	}))
 
 	caddyConfig := discovery.CaddyConfig{CaddyAdminUrl: mockServer.URL}
-	connector := NewConnector(caddyConfig)
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
 	route := NewReverseProxyRoute("subdomain.example.com", ":"+strconv.Itoa(8080))
 	routes := []Route{route}
 
-	err := connector.SetRoutes(routes)
+	err = connector.SetRoutes(routes)
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -161,12 +189,15 @@ func TestConnector_ReplaceRoutes(t *testing.T) {
 
 func TestConnector_ReplaceRouteFailsBecauseOfInvalidUrl(t *testing.T) {
 	caddyConfig := discovery.CaddyConfig{CaddyAdminUrl: "invalid-url"}
-	connector := NewConnector(caddyConfig)
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
 	route := NewReverseProxyRoute("subdomain.example.com", ":"+strconv.Itoa(8080))
 	routes := []Route{route}
 
-	err := connector.SetRoutes(routes)
+	err = connector.SetRoutes(routes)
 	if err == nil {
 		t.Errorf("Expected error, got none")
 	}
@@ -208,3 +239,304 @@ func TestNewReverseProxyRoute(t *testing.T) {
 		t.Errorf("Expected handler reverse_proxy, got %s", route.Handle[0].Routes[0].Handle[0].Upstreams[0].Dial)
 	}
 }
+
+func TestConnector_GetCaddyConfigOverUnixSocket(t *testing.T) {
+	mockResponse := "{\"apps\":{\"http\":{\"servers\":{\"srv0\":{\"listen\":[\":443\"],\"routes\":[]}}}}}\n"
+
+	socketPath := filepath.Join(t.TempDir(), "admin.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	mockServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/config/" && r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(mockResponse))
+		} else {
+			t.Errorf("Expected %s with method %s, got %s with method %s",
+				"/config/", http.MethodGet, r.URL.Path, r.Method)
+		}
+	}))
+	mockServer.Listener = listener
+	mockServer.Start()
+	defer mockServer.Close()
+
+	for _, adminUrl := range []string{"unix/" + socketPath, "unix://" + socketPath} {
+		connector, err := NewConnector(discovery.CaddyConfig{CaddyAdminUrl: adminUrl})
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %v", adminUrl, err)
+		}
+
+		config, err := connector.GetCaddyConfig()
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %v", adminUrl, err)
+		}
+		if _, ok := config.Apps.HTTP.Servers["srv0"]; !ok {
+			t.Errorf("Expected server srv0 for %s", adminUrl)
+		}
+	}
+}
+
+func TestConnector_GetCaddyConfigWithCredentialsAndCustomCA(t *testing.T) {
+	mockResponse := "{\"apps\":{\"http\":{\"servers\":{\"srv0\":{\"listen\":[\":443\"],\"routes\":[]}}}}}\n"
+
+	mockServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected bearer token, got %q", r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("Origin") != "https://discovery.internal" {
+			t.Errorf("Expected origin header, got %q", r.Header.Get("Origin"))
+		}
+		if r.Header.Get("X-Custom") != "value" {
+			t.Errorf("Expected custom header, got %q", r.Header.Get("X-Custom"))
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(mockResponse))
+	}))
+	defer mockServer.Close()
+
+	caFilePath := filepath.Join(t.TempDir(), "ca.pem")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: mockServer.Certificate().Raw})
+	if err := os.WriteFile(caFilePath, caPem, 0o600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	caddyConfig := discovery.CaddyConfig{
+		CaddyAdminUrl: mockServer.URL,
+		Admin: discovery.AdminConfig{
+			Headers:     map[string]string{"x-custom": "value"},
+			BearerToken: "secret",
+			Origin:      "https://discovery.internal",
+			TLS:         discovery.AdminTLSConfig{CAFilePath: caFilePath},
+		},
+	}
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err = connector.GetCaddyConfig(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestNewConnectorFailsBecauseOfMissingClientCertificate(t *testing.T) {
+	caddyConfig := discovery.CaddyConfig{
+		CaddyAdminUrl: "https://localhost:2019",
+		Admin: discovery.AdminConfig{
+			TLS: discovery.AdminTLSConfig{
+				CertFilePath: filepath.Join(t.TempDir(), "missing.crt"),
+				KeyFilePath:  filepath.Join(t.TempDir(), "missing.key"),
+			},
+		},
+	}
+
+	if _, err := NewConnector(caddyConfig); err == nil {
+		t.Errorf("Expected error, got none")
+	}
+}
+
+func TestConnector_SetRoutesReturnsServerErrorsWithoutSleeping(t *testing.T) {
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+
+	caddyConfig := discovery.CaddyConfig{
+		CaddyAdminUrl: mockServer.URL,
+		Retry:         discovery.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Hour, FailureThreshold: 2},
+	}
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// the retry is scheduled by the caller, so the request returns without waiting for the backoff
+	err = connector.SetRoutes([]Route{New404FallbackRoute()})
+	if !IsRetryable(err) {
+		t.Errorf("Expected retryable error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+	if connector.breaker.state != breakerClosed {
+		t.Errorf("Expected caddy to be available below the failure threshold")
+	}
+}
+
+func TestConnector_Backoff(t *testing.T) {
+	connector, err := NewConnector(discovery.CaddyConfig{
+		CaddyAdminUrl: "http://localhost:2019",
+		Retry:         discovery.RetryConfig{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// backoffs double per attempt up to the maximum, with up to 50% jitter
+	for attempt, maximum := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second} {
+		backoff := connector.Backoff(attempt + 1)
+		if backoff < maximum/2 || backoff > maximum {
+			t.Errorf("Expected backoff of attempt %d between %v and %v, got %v", attempt+1, maximum/2, maximum, backoff)
+		}
+	}
+}
+
+func TestConnector_BackoffFallsBackToDefaults(t *testing.T) {
+	connector, err := NewConnector(discovery.CaddyConfig{CaddyAdminUrl: "http://localhost:2019"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, attempt := range []int{1, 2, 64, 1 << 20} {
+		backoff := connector.Backoff(attempt)
+		if backoff < defaultInitialBackoff/2 || backoff > defaultMaxBackoff {
+			t.Errorf("Expected backoff of attempt %d between %v and %v, got %v", attempt, defaultInitialBackoff/2, defaultMaxBackoff, backoff)
+		}
+	}
+}
+
+func TestConnector_SetRoutesDoesNotRetryClientErrors(t *testing.T) {
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer mockServer.Close()
+
+	caddyConfig := discovery.CaddyConfig{
+		CaddyAdminUrl: mockServer.URL,
+		Retry:         discovery.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	}
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	err = connector.SetRoutes([]Route{New404FallbackRoute()})
+	if err == nil || IsRetryable(err) {
+		t.Errorf("Expected error that is not retryable, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+	if connector.breaker.state != breakerClosed {
+		t.Errorf("Expected caddy to be available after a client error")
+	}
+}
+
+func TestConnector_CircuitBreakerOpensAfterFailures(t *testing.T) {
+	caddyConfig := discovery.CaddyConfig{
+		CaddyAdminUrl: "invalid-url",
+		Retry:         discovery.RetryConfig{FailureThreshold: 2, OpenDuration: time.Hour},
+	}
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = connector.SetRoutes(nil); err == nil || errors.Is(err, ErrCaddyUnavailable) {
+			t.Errorf("Expected connection error, got %v", err)
+		}
+	}
+
+	if err = connector.SetRoutes(nil); !errors.Is(err, ErrCaddyUnavailable) || !IsRetryable(err) {
+		t.Errorf("Expected retryable ErrCaddyUnavailable, got %v", err)
+	}
+	if connector.breaker.state == breakerClosed {
+		t.Errorf("Expected caddy to be unavailable")
+	}
+}
+
+func TestFingerprintMatchesRoutesReadFromCaddy(t *testing.T) {
+	routes := []Route{
+		NewReverseProxyRoute("subdomain.example.com", ":8080"),
+		NewExternalReverseProxyRoute("external.example.com", "1.2.3.4:443", discovery.UpstreamTLSConfig{Enabled: true}),
+		New404FallbackRoute(),
+	}
+	expected, err := Fingerprint(routes)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// caddy returns the stored config with sorted keys and additional fields
+	config, err := UnmarshalCaddyConfig([]byte(`{"apps":{"http":{"servers":{"srv0":{"listen":[":443",":80"],"routes":[
+		{"handle":[{"handler":"subroute","routes":[{"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":":8080"}]}]}]}],"match":[{"host":["subdomain.example.com"]}]},
+		{"handle":[{"handler":"subroute","routes":[{"handle":[{"handler":"reverse_proxy","transport":{"protocol":"http","tls":{}},"upstreams":[{"dial":"1.2.3.4:443"}]}]}]}],"match":[{"host":["external.example.com"]}]},
+		{"handle":[{"body":"Not Found","handler":"static_response","status_code":404}],"match":[{}],"terminal":true}
+	]}}}}}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	actual, err := Fingerprint(config.Apps.HTTP.Servers["srv0"].Routes)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if actual != expected {
+		t.Errorf("Expected fingerprint %s, got %s", expected, actual)
+	}
+
+	changed, _ := Fingerprint(routes[1:])
+	if changed == expected {
+		t.Errorf("Expected different fingerprint for changed routes")
+	}
+}
+
+func TestConnector_CreateCaddyConfigWithAutomationPolicies(t *testing.T) {
+	var config Config
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			t.Errorf("Expected valid config, got %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockServer.Close()
+
+	caddyConfig := discovery.CaddyConfig{
+		CaddyAdminUrl: mockServer.URL,
+		TLSConfig: discovery.TLSConfig{
+			Automation: discovery.AutomationPolicyConfig{Email: "ops@example.com", Staging: true},
+			Policies: []discovery.AutomationPolicyConfig{
+				{
+					Subjects: []string{"*.example.com"},
+					Email:    "ops@example.com",
+					DNS: discovery.DNSChallengeConfig{
+						Provider: "cloudflare",
+						Options:  map[string]string{"api_token": "token"},
+					},
+				},
+				{Subjects: []string{"dev.example.com"}, Issuer: "internal"},
+			},
+		},
+	}
+	connector, err := NewConnector(caddyConfig)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err = connector.CreateCaddyConfig(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if config.Apps.TLS == nil || config.Apps.TLS.Automation == nil {
+		t.Fatalf("Expected tls automation, got none")
+	}
+	policies := config.Apps.TLS.Automation.Policies
+	if len(policies) != 3 {
+		t.Fatalf("Expected 3 policies, got %d", len(policies))
+	}
+	dns := policies[0].Issuers[0].Challenges.DNS
+	if dns.Provider["name"] != "cloudflare" || dns.Provider["api_token"] != "token" {
+		t.Errorf("Expected cloudflare dns provider, got %v", dns.Provider)
+	}
+	if policies[1].Issuers[0].Module != "internal" {
+		t.Errorf("Expected internal issuer, got %s", policies[1].Issuers[0].Module)
+	}
+	if len(policies[2].Subjects) != 0 || policies[2].Issuers[0].CA != LetsEncryptStagingCA {
+		t.Errorf("Expected global staging policy, got %+v", policies[2])
+	}
+}
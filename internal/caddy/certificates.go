@@ -0,0 +1,207 @@
+package caddy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+const (
+	defaultCertificateCheckHost        = "localhost"
+	defaultCertificateCheckTimeout     = 5 * time.Second
+	defaultCertificateCheckConcurrency = 16
+)
+
+// CertificateStatus describes the certificate Caddy serves for a routed host
+type CertificateStatus struct {
+	Host string `json:"host"`
+	// Address is the TLS listener of Caddy dialed for the host
+	Address  string    `json:"address"`
+	Issuer   string    `json:"issuer,omitempty"`
+	NotAfter time.Time `json:"notAfter,omitzero"`
+	// Valid is false if no certificate is served or the certificate is not trusted, expired or not valid for the host
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// GetLocalCARoot returns the root certificate of Caddy's local CA used by the internal issuer
+func (c *Connector) GetLocalCARoot() (*x509.Certificate, error) {
+	responseContent, err := c.do(http.MethodGet, "/pki/ca/local", "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var ca struct {
+		RootCertificate string `json:"root_certificate"`
+	}
+	if err = json.Unmarshal(responseContent, &ca); err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode([]byte(ca.RootCertificate))
+	if block == nil {
+		return nil, errors.New("no root certificate found for local CA")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// CheckCertificates dials the TLS listener of the server of every host with its SNI and verifies the served
+// certificate against the system roots and the root of Caddy's local CA. Hosts are checked concurrently.
+// Wildcard hosts are skipped, they can not be sent as SNI and probing a subdomain could make Caddy obtain
+// a certificate on demand.
+func (c *Connector) CheckCertificates(serverHosts map[string][]string) []CertificateStatus {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		slog.Warn("Could not load system root certificates", "error", err)
+		roots = x509.NewCertPool()
+	}
+	if localRoot, err := c.GetLocalCARoot(); err != nil {
+		slog.Debug("Could not load root certificate of Caddy's local CA", "error", err)
+	} else {
+		roots.AddCert(localRoot)
+	}
+
+	var statuses []CertificateStatus
+	for _, server := range c.Config.ManagedServers() {
+		address, ok := c.certificateCheckAddress(server)
+		for _, host := range serverHosts[server.Name] {
+			if strings.HasPrefix(host, "*") {
+				continue
+			}
+			if !ok {
+				statuses = append(statuses, CertificateStatus{Host: host, Error: "server has no TLS listener"})
+				continue
+			}
+			statuses = append(statuses, CertificateStatus{Host: host, Address: address})
+		}
+	}
+
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, defaultCertificateCheckConcurrency)
+	for index := range statuses {
+		if statuses[index].Address == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			statuses[index] = c.checkCertificate(statuses[index].Host, statuses[index].Address, roots)
+		}()
+	}
+	wg.Wait()
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Host < statuses[j].Host
+	})
+	return statuses
+}
+
+// certificateCheckAddress returns the TLS listener of the server to dial. The configured address wins, otherwise
+// the port of the first listen address that is not Caddy's HTTP port is used with the configured host, the host
+// of the listen address or the host of the admin api.
+func (c *Connector) certificateCheckAddress(server discovery.ServerConfig) (string, bool) {
+	if c.Config.CertificateCheck.Address != "" {
+		return c.Config.CertificateCheck.Address, true
+	}
+
+	for _, listen := range server.Listen {
+		// strip the network of addresses like tcp/:443, unix sockets can not be dialed by host
+		if network, address, ok := strings.Cut(listen, "/"); ok {
+			if strings.HasPrefix(network, "unix") {
+				continue
+			}
+			listen = address
+		}
+		listenHost, port, err := net.SplitHostPort(listen)
+		if err != nil {
+			continue
+		}
+		// port ranges like 8443-8444 are dialed at their first port
+		port, _, _ = strings.Cut(port, "-")
+		if port == "80" {
+			continue
+		}
+
+		host := c.Config.CertificateCheck.Host
+		if host == "" && listenHost != "" {
+			if ip := net.ParseIP(listenHost); ip == nil || !ip.IsUnspecified() {
+				host = listenHost
+			}
+		}
+		if host == "" {
+			host = c.adminHost()
+		}
+		return net.JoinHostPort(host, port), true
+	}
+	return "", false
+}
+
+// adminHost returns the host of the admin api, which usually runs on the same machine as the listeners
+func (c *Connector) adminHost() string {
+	adminUrl, err := url.Parse(c.Config.CaddyAdminUrl)
+	if _, ok := unixSocketPath(c.Config.CaddyAdminUrl); ok || err != nil || adminUrl.Hostname() == "" {
+		return defaultCertificateCheckHost
+	}
+	return adminUrl.Hostname()
+}
+
+func (c *Connector) checkCertificate(host string, address string, roots *x509.CertPool) CertificateStatus {
+	status := CertificateStatus{Host: host, Address: address}
+
+	timeout := c.Config.CertificateCheck.Timeout
+	if timeout <= 0 {
+		timeout = defaultCertificateCheckTimeout
+	}
+
+	// verification is done below, so expired or untrusted certificates can still be reported
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	defer conn.Close()
+
+	peerCertificates := conn.ConnectionState().PeerCertificates
+	if len(peerCertificates) == 0 {
+		status.Error = "no certificate served"
+		return status
+	}
+
+	leaf := peerCertificates[0]
+	status.Issuer = leaf.Issuer.CommonName
+	status.NotAfter = leaf.NotAfter
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range peerCertificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	status.Valid = true
+	return status
+}
@@ -0,0 +1,113 @@
+package caddy
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+func TestConnector_CheckCertificatesTrustsLocalCA(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+	rootCertificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw})
+
+	adminServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pki/ca/local" {
+			t.Errorf("Expected %s, got %s", "/pki/ca/local", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"root_certificate": string(rootCertificate)})
+	}))
+	defer adminServer.Close()
+
+	connector, err := NewConnector(discovery.CaddyConfig{
+		CaddyAdminUrl:    adminServer.URL,
+		CertificateCheck: discovery.CertificateCheckConfig{Address: tlsServer.Listener.Addr().String()},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	statuses := connector.CheckCertificates(map[string][]string{discovery.DefaultServerName: {"other.test", "*.example.com", "example.com"}})
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 statuses, got %d", len(statuses))
+	}
+
+	if statuses[0].Host != "example.com" || !statuses[0].Valid {
+		t.Errorf("Expected valid certificate for example.com, got %+v", statuses[0])
+	}
+	if statuses[0].NotAfter.IsZero() {
+		t.Errorf("Expected expiry for example.com, got none")
+	}
+	if statuses[1].Host != "other.test" || statuses[1].Valid || statuses[1].Error == "" {
+		t.Errorf("Expected invalid certificate for other.test, got %+v", statuses[1])
+	}
+}
+
+func TestConnector_CheckCertificatesReportsUnreachableListener(t *testing.T) {
+	adminServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer adminServer.Close()
+
+	connector, err := NewConnector(discovery.CaddyConfig{
+		CaddyAdminUrl:    adminServer.URL,
+		CertificateCheck: discovery.CertificateCheckConfig{Address: "127.0.0.1:1"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	statuses := connector.CheckCertificates(map[string][]string{discovery.DefaultServerName: {"example.com"}})
+	if len(statuses) != 1 || statuses[0].Valid {
+		t.Fatalf("Expected one invalid status, got %+v", statuses)
+	}
+	if !strings.Contains(statuses[0].Error, "connect") {
+		t.Errorf("Expected connection error, got %s", statuses[0].Error)
+	}
+	if content, _ := json.Marshal(statuses[0]); strings.Contains(string(content), "notAfter") {
+		t.Errorf("Expected no expiry without certificate, got %s", content)
+	}
+}
+
+func TestConnector_CheckCertificatesDialsTheListenerOfEachServer(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsServer.Close()
+	_, port, _ := net.SplitHostPort(tlsServer.Listener.Addr().String())
+
+	connector, err := NewConnector(discovery.CaddyConfig{
+		CaddyAdminUrl: "http://127.0.0.1:1",
+		Servers: []discovery.ServerConfig{
+			{Name: "public", Listen: []string{":1", ":80"}},
+			{Name: "internal", Listen: []string{":80", "tcp/127.0.0.1:" + port}},
+			{Name: "plain", Listen: []string{":80"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	statuses := connector.CheckCertificates(map[string][]string{
+		"public":   {"a.example.com"},
+		"internal": {"b.example.com"},
+		"plain":    {"c.example.com"},
+	})
+	if len(statuses) != 3 {
+		t.Fatalf("Expected 3 statuses, got %d", len(statuses))
+	}
+	if statuses[0].Address != "127.0.0.1:1" {
+		t.Errorf("Expected the public listener at the admin api host, got %s", statuses[0].Address)
+	}
+	// the certificate of the test server is not valid for the host, but it was served
+	if statuses[1].Address != "127.0.0.1:"+port || statuses[1].NotAfter.IsZero() {
+		t.Errorf("Expected the certificate of the internal listener, got %+v", statuses[1])
+	}
+	if statuses[2].Address != "" || statuses[2].Error == "" {
+		t.Errorf("Expected an error for a server without TLS listener, got %+v", statuses[2])
+	}
+}
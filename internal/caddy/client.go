@@ -0,0 +1,230 @@
+package caddy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// unixSocketBaseUrl is used as base url for requests to an admin api listening on a unix socket.
+// Caddy does not enforce the host for unix socket listeners, but Go requires a host in the url.
+const unixSocketBaseUrl = "http://localhost"
+
+// defaultInitialBackoff and defaultMaxBackoff are used if the retry config does not set valid backoffs
+const (
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+)
+
+// newAdminClient returns the http client and the base url used to reach the Caddy admin api.
+// Unix socket addresses in Caddy's notation (unix//run/caddy/admin.sock) or as url (unix:///run/caddy/admin.sock)
+// are dialed through a custom transport, all other addresses are used as they are.
+func newAdminClient(caddyConfig discovery.CaddyConfig) (*http.Client, string, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	baseUrl := caddyConfig.CaddyAdminUrl
+
+	if socketPath, ok := unixSocketPath(caddyConfig.CaddyAdminUrl); ok {
+		dialer := &net.Dialer{}
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+		baseUrl = unixSocketBaseUrl
+	}
+
+	tlsConfig, err := newAdminTLSConfig(caddyConfig.Admin.TLS)
+	if err != nil {
+		return nil, "", err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	headers := make(http.Header)
+	for name, value := range caddyConfig.Admin.Headers {
+		headers.Set(name, value)
+	}
+	if caddyConfig.Admin.BearerToken != "" {
+		headers.Set("Authorization", "Bearer "+caddyConfig.Admin.BearerToken)
+	}
+	if caddyConfig.Admin.Origin != "" {
+		headers.Set("Origin", caddyConfig.Admin.Origin)
+	}
+
+	client := &http.Client{
+		Transport: &headerTransport{base: transport, headers: headers},
+		Timeout:   caddyConfig.Retry.RequestTimeout,
+	}
+	return client, baseUrl, nil
+}
+
+// requestError is returned for responses of the admin api with a non 2xx status code
+type requestError struct {
+	url        string
+	statusCode int
+	body       string
+}
+
+func (e *requestError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("request to %s failed with status code %d", e.url, e.statusCode)
+	}
+	return fmt.Sprintf("request to %s failed with status code %d: %s", e.url, e.statusCode, e.body)
+}
+
+// retryableError marks failures worth retrying, connection errors and 5xx responses
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// IsRetryable reports whether a failed request to the admin api may succeed if it is retried,
+// because Caddy was unreachable or failed itself. Rejected requests, e.g. invalid configs, are not retryable.
+func IsRetryable(err error) bool {
+	var retryable *retryableError
+	return errors.As(err, &retryable) || errors.Is(err, ErrCaddyUnavailable)
+}
+
+// do sends a request to the admin api and returns the response body of a 2xx response.
+// Connection errors and 5xx responses count as failures of the circuit breaker and are returned as retryable,
+// while the breaker is open the request fails immediately with ErrCaddyUnavailable. Failed requests are not
+// retried here, callers schedule the retry with Backoff, so they are not blocked while waiting.
+func (c *Connector) do(method string, path string, contentType string, body []byte) ([]byte, error) {
+	if !c.breaker.allow() {
+		return nil, ErrCaddyUnavailable
+	}
+
+	responseContent, retryable, err := c.send(method, path, contentType, body)
+	if err != nil && retryable {
+		c.breaker.failure()
+		return nil, &retryableError{err: err}
+	}
+	// caddy answered, so it is reachable even if the request failed
+	c.breaker.success()
+	return responseContent, err
+}
+
+// send executes a single request and reports whether a failure is worth retrying
+func (c *Connector) send(method string, path string, contentType string, body []byte) ([]byte, bool, error) {
+	url := c.baseUrl + path
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	responseContent, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, resp.StatusCode >= 500, &requestError{
+			url:        url,
+			statusCode: resp.StatusCode,
+			body:       strings.TrimSpace(string(responseContent)),
+		}
+	}
+	return responseContent, false, nil
+}
+
+// Backoff returns the exponential backoff for the given retry attempt with up to 50% jitter.
+// Unset or invalid backoffs fall back to the defaults, so retries never follow each other without a pause.
+func (c *Connector) Backoff(attempt int) time.Duration {
+	backoff := c.Config.Retry.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultInitialBackoff
+	}
+	maxBackoff := c.Config.Retry.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	// doubling stops at the maximum, so large attempts can not overflow
+	for n := 1; n < attempt && backoff < maxBackoff; n++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxBackoff)
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// newAdminTLSConfig loads the client certificate and CA bundle used for mutual TLS with the admin api
+func newAdminTLSConfig(adminTLSConfig discovery.AdminTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: adminTLSConfig.ServerName,
+	}
+
+	if adminTLSConfig.CertFilePath != "" || adminTLSConfig.KeyFilePath != "" {
+		certificate, err := tls.LoadX509KeyPair(adminTLSConfig.CertFilePath, adminTLSConfig.KeyFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("loading admin client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if adminTLSConfig.CAFilePath != "" {
+		caBundle, err := os.ReadFile(adminTLSConfig.CAFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("loading admin CA bundle: %w", err)
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificates found in admin CA bundle %s", adminTLSConfig.CAFilePath)
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	return tlsConfig, nil
+}
+
+func unixSocketPath(adminUrl string) (string, bool) {
+	for _, prefix := range []string{"unix://", "unix/"} {
+		if strings.HasPrefix(adminUrl, prefix) {
+			return strings.TrimPrefix(adminUrl, prefix), true
+		}
+	}
+	return "", false
+}
+
+// headerTransport adds the configured headers to every request sent to the admin api
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.headers) == 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		req.Header[name] = values
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,40 @@
+package caddy
+
+import (
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// defaultEncodings are the encodings used if none are configured, in order of preference
+var defaultEncodings = []string{"zstd", "gzip"}
+
+// IsSupportedEncoding reports whether the encoding is part of a standard Caddy build
+func IsSupportedEncoding(encoding string) bool {
+	return encoding == "zstd" || encoding == "gzip"
+}
+
+// newEncodeHandle creates an encode handler compressing the responses, nil if compression is disabled
+func newEncodeHandle(compression discovery.CompressionConfig) *Handle {
+	if !compression.IsEnabled() {
+		return nil
+	}
+
+	encodings := compression.Encodings
+	if len(encodings) == 0 {
+		encodings = defaultEncodings
+	}
+	handle := &Handle{
+		Handler:       "encode",
+		Encodings:     make(map[string]struct{}, len(encodings)),
+		Prefer:        encodings,
+		MinimumLength: compression.MinimumLength,
+	}
+	for _, encoding := range encodings {
+		handle.Encodings[encoding] = struct{}{}
+	}
+	if len(compression.ContentTypes) > 0 {
+		handle.Match = &ResponseMatch{
+			Headers: map[string][]string{"Content-Type": compression.ContentTypes},
+		}
+	}
+	return handle
+}
@@ -0,0 +1,46 @@
+package caddy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+func TestNewServiceRouteCompressesResponses(t *testing.T) {
+	enabled := true
+	route := NewServiceRoute("api.example.com", ":8080", discovery.RouteOptions{
+		Compression: discovery.CompressionConfig{
+			Enabled:       &enabled,
+			Encodings:     []string{"gzip"},
+			MinimumLength: 1024,
+			ContentTypes:  []string{"application/json*", "text/*"},
+		},
+	})
+
+	handles := route.Handle[0].Routes[0].Handle
+	if len(handles) != 2 || handles[1].Handler != "reverse_proxy" {
+		t.Fatalf("Expected encode before reverse_proxy, got %+v", handles)
+	}
+
+	content, err := json.Marshal(handles[0])
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"handler":"encode","encodings":{"gzip":{}},"prefer":["gzip"],"minimum_length":1024,` +
+		`"match":{"headers":{"Content-Type":["application/json*","text/*"]}}}`
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+}
+
+func TestNewStaticRouteWithoutCompression(t *testing.T) {
+	disabled := false
+	route := NewStaticRoute("app.example.com", discovery.StaticConfig{Root: "/srv/app"},
+		discovery.RouteOptions{Compression: discovery.CompressionConfig{Enabled: &disabled}})
+
+	subroutes := route.Handle[0].Routes
+	if len(subroutes) != 1 || subroutes[0].Handle[0].Handler != "file_server" {
+		t.Errorf("Expected only the file server, got %+v", subroutes)
+	}
+}
@@ -0,0 +1,49 @@
+package caddy
+
+import (
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// newHeadersHandle creates a headers handler for the response header operations, nil if none are configured.
+// Response operations are deferred, so they also apply to the headers set by the upstream.
+func newHeadersHandle(headers discovery.HeadersConfig) *Handle {
+	if headers.Response.IsZero() {
+		return nil
+	}
+
+	return &Handle{
+		Handler: "headers",
+		Response: &ResponseHeaderOps{
+			HeaderOps: *newHeaderOps(headers.Response),
+			Deferred:  true,
+		},
+	}
+}
+
+// newProxyHeaders creates the request header operations of a reverse_proxy, nil if none are configured.
+// They are applied by the proxy after it set the X-Forwarded-* headers, so these can be overridden as well.
+func newProxyHeaders(headers discovery.HeadersConfig) *ProxyHeaders {
+	if headers.Request.IsZero() {
+		return nil
+	}
+	return &ProxyHeaders{Request: newHeaderOps(headers.Request)}
+}
+
+func newHeaderOps(ops discovery.HeaderOpsConfig) *HeaderOps {
+	return &HeaderOps{
+		Set:    headerValues(ops.Set),
+		Add:    headerValues(ops.Add),
+		Delete: ops.Delete,
+	}
+}
+
+func headerValues(values map[string]string) map[string][]string {
+	if len(values) == 0 {
+		return nil
+	}
+	headers := make(map[string][]string, len(values))
+	for name, value := range values {
+		headers[name] = []string{value}
+	}
+	return headers
+}
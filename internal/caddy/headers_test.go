@@ -0,0 +1,70 @@
+package caddy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+func TestNewServiceRouteAddsHeadersHandler(t *testing.T) {
+	route := NewServiceRoute("app.example.com", ":8080", discovery.RouteOptions{
+		Headers: discovery.HeadersConfig{
+			Request: discovery.HeaderOpsConfig{
+				Set: map[string]string{"X-Forwarded-Proto": "https"},
+			},
+			Response: discovery.HeaderOpsConfig{
+				Set:    map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+				Delete: []string{"Server"},
+			},
+		},
+	})
+
+	handles := route.Handle[0].Routes[0].Handle
+	if len(handles) != 2 || handles[0].Handler != "headers" || handles[1].Handler != "reverse_proxy" {
+		t.Fatalf("Expected headers handler before reverse_proxy, got %+v", handles)
+	}
+
+	content, err := json.Marshal(handles[0])
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"handler":"headers",` +
+		`"response":{"set":{"Strict-Transport-Security":["max-age=31536000"]},"delete":["Server"],"deferred":true}}`
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+}
+
+func TestNewServiceRouteSetsRequestHeadersOnReverseProxy(t *testing.T) {
+	route := NewServiceRoute("app.example.com", ":8080", discovery.RouteOptions{
+		Headers: discovery.HeadersConfig{
+			Request: discovery.HeaderOpsConfig{
+				Set:    map[string]string{"X-Forwarded-Proto": "https"},
+				Delete: []string{"X-Forwarded-Host"},
+			},
+		},
+	})
+
+	// the reverse proxy sets the X-Forwarded-* headers itself, so only its header operations can replace them
+	handles := route.Handle[0].Routes[0].Handle
+	if len(handles) != 1 || handles[0].Handler != "reverse_proxy" {
+		t.Fatalf("Expected only the reverse_proxy handler, got %+v", handles)
+	}
+	content, err := json.Marshal(handles[0].Headers)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"request":{"set":{"X-Forwarded-Proto":["https"]},"delete":["X-Forwarded-Host"]}}`
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+}
+
+func TestNewServiceRouteWithoutHeaders(t *testing.T) {
+	route := NewServiceRoute("app.example.com", ":8080", discovery.RouteOptions{})
+
+	if handles := route.Handle[0].Routes[0].Handle; len(handles) != 1 {
+		t.Errorf("Expected only the reverse_proxy handler, got %+v", handles)
+	}
+}
@@ -0,0 +1,341 @@
+package caddy
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// ImportIssue describes a route of an existing Caddy config that cannot be expressed as a manual route
+type ImportIssue struct {
+	Server string
+	Hosts  []string
+	Reason string
+}
+
+func (i ImportIssue) String() string {
+	return fmt.Sprintf("server %s, hosts %v: %s", i.Server, i.Hosts, i.Reason)
+}
+
+// rawObject is a JSON object of the imported config whose keys are checked before its values are read,
+// so no matcher or handler setting is dropped silently
+type rawObject map[string]json.RawMessage
+
+type rawConfig struct {
+	Apps struct {
+		HTTP struct {
+			Servers map[string]struct {
+				Routes []rawRoute `json:"routes"`
+			} `json:"servers"`
+		} `json:"http"`
+	} `json:"apps"`
+}
+
+type rawRoute struct {
+	Match  []rawObject `json:"match"`
+	Handle []rawObject `json:"handle"`
+}
+
+// ImportManualRoutes converts the reverse proxy sites of an existing Caddy JSON config into manual routes
+// of the server they were found in. Routes that cannot be represented as a manual route, including routes
+// with settings that would be lost, are returned as issues instead.
+func ImportManualRoutes(content []byte) ([]discovery.ManualRoute, []ImportIssue, error) {
+	var config rawConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, nil, err
+	}
+
+	var manualRoutes []discovery.ManualRoute
+	var issues []ImportIssue
+
+	serverNames := make([]string, 0, len(config.Apps.HTTP.Servers))
+	for name := range config.Apps.HTTP.Servers {
+		serverNames = append(serverNames, name)
+	}
+	sort.Strings(serverNames)
+
+	for _, serverName := range serverNames {
+		for _, route := range config.Apps.HTTP.Servers[serverName].Routes {
+			imported, reason := importRoute(serverName, route)
+			if reason != "" {
+				issues = append(issues, ImportIssue{
+					Server: serverName,
+					Hosts:  routeHosts(route),
+					Reason: reason,
+				})
+				continue
+			}
+			manualRoutes = append(manualRoutes, imported...)
+		}
+	}
+
+	return manualRoutes, issues, nil
+}
+
+// importRoute returns one manual route per host of the route or the reason why the route cannot be imported
+func importRoute(serverName string, route rawRoute) ([]discovery.ManualRoute, string) {
+	if len(route.Match) != 1 {
+		return nil, "route must have exactly one matcher set"
+	}
+	if keys := unsupportedKeys(route.Match[0], "host"); len(keys) > 0 {
+		return nil, fmt.Sprintf("matchers are not supported: %s", strings.Join(keys, ", "))
+	}
+	hosts := routeHosts(route)
+	if len(hosts) == 0 {
+		return nil, "route has no host matcher"
+	}
+
+	handles, reason := flattenHandles(route.Handle)
+	if reason != "" {
+		return nil, reason
+	}
+	if len(handles) != 1 {
+		return nil, fmt.Sprintf("expected a single reverse_proxy handler, got %d handlers", len(handles))
+	}
+	if handler := handlerName(handles[0]); handler != "reverse_proxy" {
+		return nil, fmt.Sprintf("unsupported handler %s", handler)
+	}
+
+	proxy, reason := importReverseProxy(handles[0])
+	if reason != "" {
+		return nil, reason
+	}
+
+	manualRoutes := make([]discovery.ManualRoute, 0, len(hosts))
+	for _, host := range hosts {
+		manualRoute := proxy
+		manualRoute.Domain = host
+		manualRoute.Server = serverName
+		manualRoutes = append(manualRoutes, manualRoute)
+	}
+	return manualRoutes, ""
+}
+
+// proxyImport reads the settings of a reverse_proxy handler and collects the settings that cannot be imported
+type proxyImport struct {
+	unsupported []string
+}
+
+// importReverseProxy converts a reverse_proxy handler into a manual route without domain, or returns the settings
+// that would be lost as reason
+func importReverseProxy(handle rawObject) (discovery.ManualRoute, string) {
+	p := &proxyImport{}
+	p.checkKeys("", handle, "handler", "upstreams", "transport", "headers")
+
+	var upstreams []rawObject
+	p.decode("", handle, "upstreams", &upstreams)
+	if len(upstreams) != 1 {
+		return discovery.ManualRoute{}, fmt.Sprintf("expected a single upstream, got %d upstreams", len(upstreams))
+	}
+	p.checkKeys("upstreams.", upstreams[0], "dial")
+	manualRoute := discovery.ManualRoute{}
+	p.decode("upstreams.", upstreams[0], "dial", &manualRoute.Upstream)
+
+	var headers rawObject
+	p.decode("", handle, "headers", &headers)
+	p.checkKeys("headers.", headers, "request")
+	manualRoute.Headers.Request = p.headerOps("headers.request.", headers)
+
+	var transport rawObject
+	p.decode("", handle, "transport", &transport)
+	if transport != nil {
+		manualRoute.Transport, manualRoute.UpstreamTLS, manualRoute.TLS = p.transport("transport.", transport)
+	}
+
+	if len(p.unsupported) > 0 {
+		return discovery.ManualRoute{}, fmt.Sprintf("reverse_proxy settings cannot be imported: %s", strings.Join(p.unsupported, ", "))
+	}
+	return manualRoute, ""
+}
+
+// transport reads the http transport settings, tls is set if a plain tls flag is enough for the upstream TLS settings
+func (p *proxyImport) transport(path string, transport rawObject) (discovery.TransportConfig, discovery.UpstreamTLSConfig, bool) {
+	p.checkKeys(path, transport, "protocol", "tls", "dial_timeout", "response_header_timeout", "read_timeout",
+		"write_timeout", "keep_alive", "versions", "max_conns_per_host")
+
+	var protocol string
+	p.decode(path, transport, "protocol", &protocol)
+	if protocol != "" && protocol != "http" {
+		p.unsupported = append(p.unsupported, path+"protocol "+protocol)
+	}
+
+	transportConfig := discovery.TransportConfig{
+		DialTimeout:           p.duration(path, transport, "dial_timeout"),
+		ResponseHeaderTimeout: p.duration(path, transport, "response_header_timeout"),
+		ReadTimeout:           p.duration(path, transport, "read_timeout"),
+		WriteTimeout:          p.duration(path, transport, "write_timeout"),
+	}
+	p.decode(path, transport, "versions", &transportConfig.Versions)
+	p.decode(path, transport, "max_conns_per_host", &transportConfig.MaxConnsPerHost)
+
+	var keepAlive rawObject
+	p.decode(path, transport, "keep_alive", &keepAlive)
+	p.checkKeys(path+"keep_alive.", keepAlive, "enabled", "idle_timeout", "max_idle_conns_per_host")
+	var keepAliveEnabled *bool
+	p.decode(path+"keep_alive.", keepAlive, "enabled", &keepAliveEnabled)
+	transportConfig.KeepAlive = discovery.KeepAliveConfig{
+		Disabled:    keepAliveEnabled != nil && !*keepAliveEnabled,
+		IdleTimeout: p.duration(path+"keep_alive.", keepAlive, "idle_timeout"),
+	}
+	p.decode(path+"keep_alive.", keepAlive, "max_idle_conns_per_host", &transportConfig.KeepAlive.MaxIdleConnsPerHost)
+
+	var transportTLS rawObject
+	p.decode(path, transport, "tls", &transportTLS)
+	if transportTLS == nil {
+		return transportConfig, discovery.UpstreamTLSConfig{}, false
+	}
+	tlsPath := path + "tls."
+	p.checkKeys(tlsPath, transportTLS, "server_name", "root_ca_pem_files", "client_certificate_file",
+		"client_certificate_key_file", "insecure_skip_verify")
+	var upstreamTLS discovery.UpstreamTLSConfig
+	var rootCAFiles []string
+	p.decode(tlsPath, transportTLS, "server_name", &upstreamTLS.ServerName)
+	p.decode(tlsPath, transportTLS, "root_ca_pem_files", &rootCAFiles)
+	p.decode(tlsPath, transportTLS, "client_certificate_file", &upstreamTLS.CertFilePath)
+	p.decode(tlsPath, transportTLS, "client_certificate_key_file", &upstreamTLS.KeyFilePath)
+	p.decode(tlsPath, transportTLS, "insecure_skip_verify", &upstreamTLS.InsecureSkipVerify)
+	switch len(rootCAFiles) {
+	case 0:
+	case 1:
+		upstreamTLS.CAFilePath = rootCAFiles[0]
+	default:
+		p.unsupported = append(p.unsupported, tlsPath+"root_ca_pem_files with more than one file")
+	}
+	// a plain tls flag is enough if no further upstream TLS options are used
+	return transportConfig, upstreamTLS, !upstreamTLS.IsEnabled()
+}
+
+// headerOps reads request header operations, header values are imported if they are single values
+func (p *proxyImport) headerOps(path string, headers rawObject) discovery.HeaderOpsConfig {
+	var request rawObject
+	p.decode("headers.", headers, "request", &request)
+	p.checkKeys(path, request, "set", "add", "delete")
+
+	var ops HeaderOps
+	p.decode(path, request, "set", &ops.Set)
+	p.decode(path, request, "add", &ops.Add)
+	p.decode(path, request, "delete", &ops.Delete)
+	return discovery.HeaderOpsConfig{
+		Set:    p.singleValues(path+"set.", ops.Set),
+		Add:    p.singleValues(path+"add.", ops.Add),
+		Delete: ops.Delete,
+	}
+}
+
+func (p *proxyImport) singleValues(path string, values map[string][]string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	single := make(map[string]string, len(values))
+	for name, headerValues := range values {
+		if len(headerValues) != 1 {
+			p.unsupported = append(p.unsupported, path+name+" with several values")
+			continue
+		}
+		single[name] = headerValues[0]
+	}
+	return single
+}
+
+// checkKeys reports the keys of the object that are not supported
+func (p *proxyImport) checkKeys(path string, object rawObject, supported ...string) {
+	for _, key := range unsupportedKeys(object, supported...) {
+		p.unsupported = append(p.unsupported, path+key)
+	}
+}
+
+// decode reads the value of the key into target if it is set, invalid values are reported as unsupported
+func (p *proxyImport) decode(path string, object rawObject, key string, target any) {
+	value, ok := object[key]
+	if !ok {
+		return
+	}
+	if err := json.Unmarshal(value, target); err != nil {
+		p.unsupported = append(p.unsupported, path+key)
+	}
+}
+
+// duration reads a Caddy duration, which is either a duration string or an integer of nanoseconds
+func (p *proxyImport) duration(path string, object rawObject, key string) time.Duration {
+	value, ok := object[key]
+	if !ok {
+		return 0
+	}
+	var nanoseconds int64
+	if err := json.Unmarshal(value, &nanoseconds); err == nil {
+		return time.Duration(nanoseconds)
+	}
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		if duration, err := time.ParseDuration(text); err == nil {
+			return duration
+		}
+	}
+	p.unsupported = append(p.unsupported, path+key)
+	return 0
+}
+
+// flattenHandles resolves nested subroutes without matchers into a flat handler chain
+func flattenHandles(handles []rawObject) ([]rawObject, string) {
+	var flattened []rawObject
+	for _, handle := range handles {
+		if handlerName(handle) != "subroute" {
+			flattened = append(flattened, handle)
+			continue
+		}
+		if keys := unsupportedKeys(handle, "handler", "routes"); len(keys) > 0 {
+			return nil, fmt.Sprintf("subroute settings are not supported: %s", strings.Join(keys, ", "))
+		}
+
+		var subroutes []rawRoute
+		if routes, ok := handle["routes"]; ok {
+			if err := json.Unmarshal(routes, &subroutes); err != nil {
+				return nil, fmt.Sprintf("invalid subroutes: %v", err)
+			}
+		}
+		for _, subroute := range subroutes {
+			if len(subroute.Match) > 0 {
+				return nil, "subroutes with matchers are not supported"
+			}
+			nested, reason := flattenHandles(subroute.Handle)
+			if reason != "" {
+				return nil, reason
+			}
+			flattened = append(flattened, nested...)
+		}
+	}
+	return flattened, ""
+}
+
+func handlerName(handle rawObject) string {
+	var handler string
+	_ = json.Unmarshal(handle["handler"], &handler)
+	return handler
+}
+
+// unsupportedKeys returns the sorted keys of the object that are not supported
+func unsupportedKeys(object rawObject, supported ...string) []string {
+	var keys []string
+	for key := range object {
+		if !slices.Contains(supported, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func routeHosts(route rawRoute) []string {
+	var hosts []string
+	for _, match := range route.Match {
+		var matchHosts []string
+		_ = json.Unmarshal(match["host"], &matchHosts)
+		hosts = append(hosts, matchHosts...)
+	}
+	return hosts
+}
@@ -0,0 +1,125 @@
+package caddy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImportManualRoutes(t *testing.T) {
+	manualRoutes, issues, err := ImportManualRoutes([]byte(`{"apps":{"http":{"servers":{"srv0":{"listen":[":443"],"routes":[
+		{"match":[{"host":["a.example.com","b.example.com"]}],"handle":[{"handler":"subroute","routes":[{"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.0.1:8080"}]}]}]}],"terminal":true},
+		{"match":[{"host":["secure.example.com"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.0.2:443"}],"transport":{"protocol":"http","tls":{}}}]},
+		{"match":[{"host":["backend.example.com"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.0.4:443"}],"transport":{"protocol":"http","tls":{"server_name":"backend.internal","insecure_skip_verify":true}}}]},
+		{"match":[{"host":["static.example.com"]}],"handle":[{"handler":"file_server"}]},
+		{"match":[{"host":["api.example.com"],"path":["/v1/*"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.0.3:80"}]}]},
+		{"match":[{}],"handle":[{"handler":"static_response","status_code":404,"body":"Not Found"}]}
+	]}}}}}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(manualRoutes) != 4 {
+		t.Fatalf("Expected 4 manual routes, got %d", len(manualRoutes))
+	}
+	if manualRoutes[0].Domain != "a.example.com" || manualRoutes[1].Domain != "b.example.com" {
+		t.Errorf("Expected a route per host, got %+v", manualRoutes[:2])
+	}
+	if manualRoutes[0].Upstream != "10.0.0.1:8080" || manualRoutes[0].TLS || manualRoutes[0].Server != "srv0" {
+		t.Errorf("Expected plain upstream 10.0.0.1:8080 on srv0, got %+v", manualRoutes[0])
+	}
+	if manualRoutes[2].Domain != "secure.example.com" || !manualRoutes[2].TLS {
+		t.Errorf("Expected tls route for secure.example.com, got %+v", manualRoutes[2])
+	}
+	upstreamTLS := manualRoutes[3].UpstreamTLS
+	if upstreamTLS.ServerName != "backend.internal" || !upstreamTLS.InsecureSkipVerify || manualRoutes[3].TLS {
+		t.Errorf("Expected upstream TLS options for backend.example.com, got %+v", manualRoutes[3])
+	}
+
+	if len(issues) != 3 {
+		t.Fatalf("Expected 3 issues, got %d: %v", len(issues), issues)
+	}
+	if issues[0].Hosts[0] != "static.example.com" {
+		t.Errorf("Expected issue for static.example.com, got %v", issues[0])
+	}
+	if issues[1].Hosts[0] != "api.example.com" {
+		t.Errorf("Expected issue for api.example.com, got %v", issues[1])
+	}
+	if len(issues[2].Hosts) != 0 {
+		t.Errorf("Expected issue for the fallback route, got %v", issues[2])
+	}
+}
+
+func TestImportManualRoutesReportsSettingsThatWouldBeLost(t *testing.T) {
+	tests := map[string]struct {
+		route  string
+		reason string
+	}{
+		"client address restriction": {
+			route:  `{"match":[{"host":["a.example.com"],"remote_ip":{"ranges":["10.0.0.0/8"]}}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.0.1:80"}]}]}`,
+			reason: "matchers are not supported: remote_ip",
+		},
+		"matchers unknown to the config model": {
+			route:  `{"match":[{"host":["a.example.com"],"method":["GET"],"query":{"debug":["1"]}}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.0.1:80"}]}]}`,
+			reason: "matchers are not supported: method, query",
+		},
+		"load balancing and response headers": {
+			route: `{"match":[{"host":["a.example.com"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.0.1:80"}],` +
+				`"load_balancing":{"retries":3},"headers":{"response":{"delete":["Server"]}}}]}`,
+			reason: "reverse_proxy settings cannot be imported: load_balancing, headers.response",
+		},
+		"unsupported transport settings": {
+			route: `{"match":[{"host":["a.example.com"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.0.1:80","max_requests":10}],` +
+				`"transport":{"protocol":"http","keep_alive":{"probe_interval":"30s"},"tls":{"ca":{"provider":"file"}}}}]}`,
+			reason: "reverse_proxy settings cannot be imported: upstreams.max_requests, transport.keep_alive.probe_interval, transport.tls.ca",
+		},
+		"fastcgi transport": {
+			route:  `{"match":[{"host":["a.example.com"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"unix//run/php.sock"}],"transport":{"protocol":"fastcgi"}}]}`,
+			reason: "reverse_proxy settings cannot be imported: transport.protocol fastcgi",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			manualRoutes, issues, err := ImportManualRoutes([]byte(`{"apps":{"http":{"servers":{"srv0":{"routes":[` + test.route + `]}}}}}`))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(manualRoutes) != 0 {
+				t.Errorf("Expected no manual routes, got %+v", manualRoutes)
+			}
+			if len(issues) != 1 || issues[0].Reason != test.reason {
+				t.Errorf("Expected issue %q, got %v", test.reason, issues)
+			}
+		})
+	}
+}
+
+func TestImportManualRoutesKeepsServerTransportAndRequestHeaders(t *testing.T) {
+	manualRoutes, issues, err := ImportManualRoutes([]byte(`{"apps":{"http":{"servers":{"internal":{"routes":[
+		{"match":[{"host":["api.internal"]}],"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"10.0.0.1:8080"}],
+			"headers":{"request":{"set":{"X-Forwarded-Proto":["https"]},"delete":["X-Debug"]}},
+			"transport":{"protocol":"http","dial_timeout":5000000000,"read_timeout":"1m","versions":["h2c","2"],"keep_alive":{"enabled":false}}}]}
+	]}}}}}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(issues) != 0 || len(manualRoutes) != 1 {
+		t.Fatalf("Expected one manual route without issues, got %+v and %v", manualRoutes, issues)
+	}
+
+	manualRoute := manualRoutes[0]
+	if manualRoute.Server != "internal" {
+		t.Errorf("Expected server internal, got %s", manualRoute.Server)
+	}
+	transport := manualRoute.Transport
+	if transport.DialTimeout != 5*time.Second || transport.ReadTimeout != time.Minute || len(transport.Versions) != 2 || !transport.KeepAlive.Disabled {
+		t.Errorf("Expected imported transport settings, got %+v", transport)
+	}
+	if manualRoute.TLS || manualRoute.UpstreamTLS.IsEnabled() {
+		t.Errorf("Expected no upstream TLS, got %+v", manualRoute)
+	}
+	request := manualRoute.Headers.Request
+	if request.Set["X-Forwarded-Proto"] != "https" || len(request.Delete) != 1 || request.Delete[0] != "X-Debug" {
+		t.Errorf("Expected imported request headers, got %+v", request)
+	}
+}
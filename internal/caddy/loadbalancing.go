@@ -0,0 +1,41 @@
+package caddy
+
+import "github.com/jaku01/caddyservicediscovery/internal/discovery"
+
+// newLoadBalancing selects the upstream by the policy of the config, or by the weights if the config has no policy.
+// The weights are the fallback of the cookie and header policies for requests without cookie or header,
+// the hash policies ignore them. Nil if neither is set.
+func newLoadBalancing(config discovery.LoadBalancingConfig, weights []int) *LoadBalancing {
+	var weighted *SelectionPolicy
+	if len(weights) > 0 {
+		weighted = &SelectionPolicy{
+			Policy:  "weighted_round_robin",
+			Weights: weights,
+		}
+	}
+
+	var policy *SelectionPolicy
+	switch config.Policy {
+	case "":
+		policy = weighted
+	case discovery.LoadBalancingCookie:
+		policy = &SelectionPolicy{
+			Policy:   config.Policy,
+			Name:     config.CookieName,
+			Secret:   config.CookieSecret,
+			Fallback: weighted,
+		}
+	case discovery.LoadBalancingHeader:
+		policy = &SelectionPolicy{
+			Policy:   config.Policy,
+			Field:    config.Header,
+			Fallback: weighted,
+		}
+	default:
+		policy = &SelectionPolicy{Policy: config.Policy}
+	}
+	if policy == nil {
+		return nil
+	}
+	return &LoadBalancing{SelectionPolicy: policy}
+}
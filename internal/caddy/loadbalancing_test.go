@@ -0,0 +1,68 @@
+package caddy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+func TestNewVersionedServiceRouteUsesStickyCookie(t *testing.T) {
+	route := NewVersionedServiceRoute("app.example.com", []VersionedUpstream{
+		{Dial: "10.0.0.1:8080", Weight: 3},
+		{Dial: "10.0.0.2:8080", Weight: 1},
+	}, true, discovery.RouteOptions{
+		LoadBalancing: discovery.LoadBalancingConfig{
+			Policy:       discovery.LoadBalancingCookie,
+			CookieName:   "session",
+			CookieSecret: "s3cret",
+		},
+	})
+
+	content, err := json.Marshal(route.Handle[0].Routes[0].Handle[0].LoadBalancing)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// new clients are distributed by weight before the cookie pins them to their upstream
+	expected := `{"selection_policy":{"policy":"cookie","name":"session","secret":"s3cret",` +
+		`"fallback":{"policy":"weighted_round_robin","weights":[3,1]}}}`
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+}
+
+func TestNewLoadBalancing(t *testing.T) {
+	tests := []struct {
+		config   discovery.LoadBalancingConfig
+		expected string
+	}{
+		{discovery.LoadBalancingConfig{Policy: discovery.LoadBalancingIPHash}, `{"policy":"ip_hash"}`},
+		{discovery.LoadBalancingConfig{Policy: discovery.LoadBalancingClientIPHash}, `{"policy":"client_ip_hash"}`},
+		{discovery.LoadBalancingConfig{Policy: discovery.LoadBalancingURIHash}, `{"policy":"uri_hash"}`},
+		{discovery.LoadBalancingConfig{Policy: discovery.LoadBalancingHeader, Header: "X-User"}, `{"policy":"header","field":"X-User"}`},
+	}
+
+	for _, test := range tests {
+		content, err := json.Marshal(newLoadBalancing(test.config, nil).SelectionPolicy)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if string(content) != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, content)
+		}
+	}
+
+	if loadBalancing := newLoadBalancing(discovery.LoadBalancingConfig{}, nil); loadBalancing != nil {
+		t.Errorf("Expected Caddy's default selection without policy, got %+v", loadBalancing)
+	}
+}
+
+func TestNewServiceRouteWithoutLoadBalancing(t *testing.T) {
+	route := NewServiceRoute("app.example.com", ":8080", discovery.RouteOptions{
+		LoadBalancing: discovery.LoadBalancingConfig{Policy: discovery.LoadBalancingIPHash},
+	})
+
+	if loadBalancing := route.Handle[0].Routes[0].Handle[0].LoadBalancing; loadBalancing != nil {
+		t.Errorf("Expected no load balancing for a single upstream, got %+v", loadBalancing)
+	}
+}
@@ -0,0 +1,95 @@
+package caddy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// defaultErrorStatusCodes are the errors answered with the error page if no status codes are configured
+var defaultErrorStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// NewFallbackRoute creates the route answering requests without a matching route with the page,
+// the 404 fallback route if the page is not configured
+func NewFallbackRoute(page discovery.PageConfig) Route {
+	if page.IsZero() {
+		return New404FallbackRoute()
+	}
+	if page.Status == 0 {
+		page.Status = http.StatusNotFound
+	}
+
+	return Route{
+		Match:  []Match{{}}, // match everything
+		Handle: newPageHandles(page),
+	}
+}
+
+// NewServerErrors creates the error routes of a server answering the configured error status codes with
+// the error page, nil if no error page is configured
+func NewServerErrors(errorPages discovery.ErrorPagesConfig) *ServerErrors {
+	if errorPages.Body == "" {
+		return nil
+	}
+
+	statusCodes := errorPages.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = defaultErrorStatusCodes
+	}
+
+	routes := make([]Route, 0, len(statusCodes))
+	for _, statusCode := range statusCodes {
+		page := errorPages.PageConfig
+		page.Status = statusCode
+		routes = append(routes, Route{
+			Match:  []Match{{Expression: fmt.Sprintf("{http.error.status_code} == %d", statusCode)}},
+			Handle: newPageHandles(page),
+		})
+	}
+	return &ServerErrors{Routes: routes}
+}
+
+// newPageHandles creates the handlers responding with the page
+func newPageHandles(page discovery.PageConfig) []Handle {
+	var handles []Handle
+	if page.ContentType != "" {
+		handles = append(handles, Handle{
+			Handler: "headers",
+			Response: &ResponseHeaderOps{
+				HeaderOps: HeaderOps{
+					Set: map[string][]string{"Content-Type": {page.ContentType}},
+				},
+			},
+		})
+	}
+	return append(handles, Handle{
+		Handler:    "static_response",
+		StatusCode: page.Status,
+		Body:       page.Body,
+	})
+}
+
+// NewMaintenanceRoute creates the route of a domain in maintenance, answering with the maintenance page
+// and status 503 instead of forwarding to the upstream
+func NewMaintenanceRoute(domain string, maintenance discovery.MaintenanceConfig, options discovery.RouteOptions) Route {
+	page := maintenance.PageConfig
+	page.Status = http.StatusServiceUnavailable
+	if page.Body == "" {
+		page.Body = "Service Unavailable"
+	}
+
+	handles := newPageHandles(page)
+	if retryAfter := int(maintenance.RetryAfter.Seconds()); retryAfter > 0 {
+		handles = append([]Handle{{
+			Handler: "headers",
+			Response: &ResponseHeaderOps{
+				HeaderOps: HeaderOps{
+					Set: map[string][]string{"Retry-After": {strconv.Itoa(retryAfter)}},
+				},
+			},
+		}}, handles...)
+	}
+	return newRoute(domain, options, handles)
+}
@@ -0,0 +1,68 @@
+package caddy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+func TestNewFallbackRouteDefaultsToNotFound(t *testing.T) {
+	expected, _ := Fingerprint(New404FallbackRoute())
+	actual, _ := Fingerprint(NewFallbackRoute(discovery.PageConfig{}))
+
+	if actual != expected {
+		t.Errorf("Expected the 404 fallback route without configured page")
+	}
+}
+
+func TestNewFallbackRouteServesPage(t *testing.T) {
+	route := NewFallbackRoute(discovery.PageConfig{Body: "Unknown site", ContentType: "text/html"})
+
+	content, err := json.Marshal(route)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"match":[{}],"handle":[{"handler":"headers","response":{"set":{"Content-Type":["text/html"]}}},` +
+		`{"handler":"static_response","status_code":404,"body":"Unknown site"}]}`
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+}
+
+func TestNewServerErrorsMatchesStatusCodes(t *testing.T) {
+	if errors := NewServerErrors(discovery.ErrorPagesConfig{}); errors != nil {
+		t.Errorf("Expected no error routes without page, got %+v", errors)
+	}
+
+	errors := NewServerErrors(discovery.ErrorPagesConfig{
+		PageConfig: discovery.PageConfig{Body: "Service unavailable, request {http.request.uuid}"},
+	})
+
+	if errors == nil || len(errors.Routes) != 3 {
+		t.Fatalf("Expected error routes for 502, 503 and 504, got %+v", errors)
+	}
+	route := errors.Routes[1]
+	if route.Match[0].Expression != "{http.error.status_code} == 503" {
+		t.Errorf("Expected match of status 503, got %s", route.Match[0].Expression)
+	}
+	if route.Handle[0].StatusCode != 503 || route.Handle[0].Body != "Service unavailable, request {http.request.uuid}" {
+		t.Errorf("Expected 503 error page, got %+v", route.Handle[0])
+	}
+}
+
+func TestNewMaintenanceRouteReplacesProxy(t *testing.T) {
+	route := NewMaintenanceRoute("app.example.com", discovery.MaintenanceConfig{RetryAfter: 2 * time.Minute},
+		discovery.RouteOptions{})
+
+	content, err := json.Marshal(route.Handle[0].Routes)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `[{"handle":[{"handler":"headers","response":{"set":{"Retry-After":["120"]}}},` +
+		`{"handler":"static_response","status_code":503,"body":"Service Unavailable"}]}]`
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+}
@@ -0,0 +1,48 @@
+package caddy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// NewRedirectRoute creates a route redirecting the hosts to the target, a host or an url, keeping path and query.
+// Targets without scheme are redirected with the given scheme.
+func NewRedirectRoute(hosts []string, scheme string, target string, statusCode int) Route {
+	if !strings.Contains(target, "://") {
+		target = scheme + "://" + target
+	}
+
+	return Route{
+		Handle: []Handle{
+			{
+				Handler: "headers",
+				Response: &ResponseHeaderOps{
+					HeaderOps: HeaderOps{
+						Set: map[string][]string{
+							"Location": {strings.TrimSuffix(target, "/") + "{http.request.uri}"},
+						},
+					},
+				},
+			},
+			{
+				Handler:    "static_response",
+				StatusCode: statusCode,
+			},
+		},
+		Match: []Match{
+			{
+				Host: hosts,
+			},
+		},
+	}
+}
+
+// IsRedirectStatus reports whether Caddy can redirect with the status code
+func IsRedirectStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package caddy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNewRedirectRouteKeepsPathAndQuery(t *testing.T) {
+	route := NewRedirectRoute([]string{"example.com"}, "https", "www.example.com", 308)
+
+	content, err := json.Marshal(route)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"match":[{"host":["example.com"]}],"handle":[` +
+		`{"handler":"headers","response":{"set":{"Location":["https://www.example.com{http.request.uri}"]}}},` +
+		`{"handler":"static_response","status_code":308}]}`
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+}
+
+func TestNewRedirectRouteToUrl(t *testing.T) {
+	route := NewRedirectRoute([]string{"old.example.com"}, "https", "http://new.example.com/", 302)
+
+	location := route.Handle[0].Response.Set["Location"][0]
+	if location != "http://new.example.com{http.request.uri}" {
+		t.Errorf("Expected location http://new.example.com{http.request.uri}, got %s", location)
+	}
+}
+
+func TestNewRedirectRouteUsesScheme(t *testing.T) {
+	route := NewRedirectRoute([]string{"old.example.com"}, "http", "new.example.com", 301)
+
+	location := route.Handle[0].Response.Set["Location"][0]
+	if location != "http://new.example.com{http.request.uri}" {
+		t.Errorf("Expected location http://new.example.com{http.request.uri}, got %s", location)
+	}
+}
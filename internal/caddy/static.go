@@ -0,0 +1,44 @@
+package caddy
+
+import (
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// NewStaticRoute creates a route serving the files of the static config for the domain, compressed unless
+// compression is disabled. Single page applications get /index.html for all paths without a file.
+func NewStaticRoute(domain string, static discovery.StaticConfig, options discovery.RouteOptions) Route {
+	var routes []Route
+	if static.SPA {
+		routes = append(routes, Route{
+			Match: []Match{
+				{
+					File: &FileMatch{
+						Root:     static.Root,
+						TryFiles: []string{"{http.request.uri.path}", "/index.html"},
+					},
+				},
+			},
+			Handle: []Handle{
+				{
+					Handler: "rewrite",
+					URI:     "{http.matchers.file.relative}",
+				},
+			},
+		})
+	}
+	routes = append(routes, Route{
+		Handle: []Handle{
+			{
+				Handler:    "file_server",
+				Root:       static.Root,
+				IndexNames: static.IndexNames,
+			},
+		},
+	})
+
+	if options.Compression.Enabled == nil {
+		enabled := true
+		options.Compression.Enabled = &enabled
+	}
+	return newRoute(domain, options, nil, routes...)
+}
@@ -0,0 +1,41 @@
+package caddy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+func TestNewStaticRouteServesSinglePageApplications(t *testing.T) {
+	route := NewStaticRoute("app.example.com", discovery.StaticConfig{Root: "/srv/app", SPA: true}, discovery.RouteOptions{})
+
+	content, err := json.Marshal(route.Handle[0].Routes)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `[{"handle":[{"handler":"encode","encodings":{"gzip":{},"zstd":{}},"prefer":["zstd","gzip"]}]},` +
+		`{"match":[{"file":{"root":"/srv/app","try_files":["{http.request.uri.path}","/index.html"]}}],` +
+		`"handle":[{"handler":"rewrite","uri":"{http.matchers.file.relative}"}]},` +
+		`{"handle":[{"handler":"file_server","root":"/srv/app"}]}]`
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+}
+
+func TestNewStaticRouteAppliesRouteOptions(t *testing.T) {
+	route := NewStaticRoute("docs.example.com", discovery.StaticConfig{Root: "/srv/docs", IndexNames: []string{"README.html"}},
+		discovery.RouteOptions{Access: discovery.AccessConfig{Allow: []string{"10.0.0.0/8"}}})
+
+	subroutes := route.Handle[0].Routes
+	if len(subroutes) != 3 {
+		t.Fatalf("Expected access check, encode and file server routes, got %d subroutes", len(subroutes))
+	}
+	if subroutes[0].Handle[0].StatusCode != 403 {
+		t.Errorf("Expected access check first, got %+v", subroutes[0].Handle[0])
+	}
+	fileServer := subroutes[2].Handle[0]
+	if fileServer.Handler != "file_server" || fileServer.IndexNames[0] != "README.html" {
+		t.Errorf("Expected file server with index README.html, got %+v", fileServer)
+	}
+}
@@ -0,0 +1,185 @@
+package caddy
+
+import (
+	"log/slog"
+	"sort"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// LetsEncryptStagingCA is the ACME directory of the Let's Encrypt staging environment
+const LetsEncryptStagingCA = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+// newTLSApp creates the tls app from the TLS configuration, nil if Caddy's defaults should be used
+func newTLSApp(tlsConfig discovery.TLSConfig) *TLSApp {
+	tlsApp := &TLSApp{}
+	configured := false
+
+	if tlsConfig.Manual {
+		slog.Info("Using manual TLS configuration",
+			"certFilePath", tlsConfig.CertFilePath,
+			"keyFilePath", tlsConfig.KeyFilePath)
+
+		tlsApp.Certificates.LoadFiles = []LoadFile{
+			{
+				Certificate: tlsConfig.CertFilePath,
+				Key:         tlsConfig.KeyFilePath,
+			},
+		}
+		configured = true
+	}
+
+	for _, certificate := range tlsConfig.Certificates {
+		tlsApp.Certificates.LoadFiles = append(tlsApp.Certificates.LoadFiles, LoadFile{
+			Certificate: certificate.CertFilePath,
+			Key:         certificate.KeyFilePath,
+			Tags:        []string{certificate.SelectionTag()},
+		})
+		configured = true
+	}
+	if len(tlsConfig.CertificateFolders) > 0 {
+		tlsApp.Certificates.LoadFolders = tlsConfig.CertificateFolders
+		configured = true
+	}
+
+	var policies []AutomationPolicy
+	if len(tlsConfig.Internal.BaseDomains) > 0 {
+		slog.Info("Using internal issuer for development domains",
+			"baseDomains", tlsConfig.Internal.BaseDomains, "wildcard", tlsConfig.Internal.Wildcard)
+
+		policies = append(policies, newInternalPolicy(tlsConfig.Internal.BaseDomains))
+		if tlsConfig.Internal.Wildcard {
+			for _, baseDomain := range tlsConfig.Internal.BaseDomains {
+				tlsApp.Certificates.Automate = append(tlsApp.Certificates.Automate, "*."+baseDomain)
+			}
+		}
+	}
+	for _, policyConfig := range tlsConfig.Policies {
+		policies = append(policies, newAutomationPolicy(policyConfig))
+	}
+	if !tlsConfig.Automation.IsZero() {
+		// the global policy has no subjects, so it has to be the last one
+		globalPolicy := newAutomationPolicy(tlsConfig.Automation)
+		globalPolicy.Subjects = nil
+		policies = append(policies, globalPolicy)
+	}
+	if tlsConfig.OnDemand.Enabled {
+		policies = newOnDemandPolicies(policies, tlsConfig.Automation.IsZero())
+	}
+	if len(policies) > 0 {
+		tlsApp.Automation = &TLSAutomation{Policies: policies}
+		configured = true
+	}
+	if tlsConfig.OnDemand.Enabled {
+		slog.Info("Using on-demand TLS", "askUrl", tlsConfig.OnDemand.AskUrl)
+		tlsApp.Automation.OnDemand = &OnDemand{
+			Permission: &OnDemandPermission{
+				Module:   "http",
+				Endpoint: tlsConfig.OnDemand.AskUrl,
+			},
+		}
+	}
+
+	if !configured {
+		return nil
+	}
+	return tlsApp
+}
+
+// newInternalPolicy groups the base domains and their subdomains under one policy using the internal issuer
+func newInternalPolicy(baseDomains []string) AutomationPolicy {
+	subjects := make([]string, 0, 2*len(baseDomains))
+	for _, baseDomain := range baseDomains {
+		subjects = append(subjects, baseDomain, "*."+baseDomain)
+	}
+	return AutomationPolicy{
+		Subjects: subjects,
+		Issuers:  []Issuer{{Module: "internal"}},
+	}
+}
+
+// newOnDemandPolicies enables on-demand TLS for all policies and adds a catch-all on-demand policy
+// if no global policy exists
+func newOnDemandPolicies(policies []AutomationPolicy, withoutGlobalPolicy bool) []AutomationPolicy {
+	for i := range policies {
+		policies[i].OnDemand = true
+	}
+	if withoutGlobalPolicy {
+		policies = append(policies, AutomationPolicy{OnDemand: true})
+	}
+	return policies
+}
+
+// newAutomationPolicy creates an automation policy with a single acme or internal issuer
+func newAutomationPolicy(policyConfig discovery.AutomationPolicyConfig) AutomationPolicy {
+	issuer := Issuer{Module: policyConfig.Issuer}
+	if issuer.Module == "" {
+		issuer.Module = "acme"
+	}
+
+	if issuer.Module == "acme" {
+		issuer.CA = policyConfig.CA
+		if issuer.CA == "" && policyConfig.Staging {
+			issuer.CA = LetsEncryptStagingCA
+		}
+		issuer.Email = policyConfig.Email
+
+		if policyConfig.DNS.Provider != "" {
+			provider := map[string]string{"name": policyConfig.DNS.Provider}
+			for key, value := range policyConfig.DNS.Options {
+				provider[key] = value
+			}
+			issuer.Challenges = &IssuerChallenges{
+				DNS: &DNSChallenge{
+					Provider:  provider,
+					Resolvers: policyConfig.DNS.Resolvers,
+				},
+			}
+		}
+	}
+
+	return AutomationPolicy{
+		Subjects: policyConfig.Subjects,
+		Issuers:  []Issuer{issuer},
+	}
+}
+
+// NewCertificateSelectionPolicy creates a connection policy serving the certificate with the given tag for the hosts
+func NewCertificateSelectionPolicy(hosts []string, tag string) TLSConnectionPolicy {
+	return TLSConnectionPolicy{
+		Match: &TLSConnectionMatch{
+			SNI: hosts,
+		},
+		CertificateSelection: &CertificateSelection{
+			AnyTag: []string{tag},
+		},
+	}
+}
+
+// NewTLSConnectionPolicies creates the connection policies of a server from the certificates with hosts
+// and the certificates selected by routes (domain -> tag). A catch-all policy is added as last policy,
+// because Caddy rejects handshakes not matched by any policy.
+func NewTLSConnectionPolicies(certificates []discovery.CertificateConfig, routeCertificates map[string]string) []TLSConnectionPolicy {
+	var policies []TLSConnectionPolicy
+
+	domains := make([]string, 0, len(routeCertificates))
+	for domain := range routeCertificates {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		policies = append(policies, NewCertificateSelectionPolicy([]string{domain}, routeCertificates[domain]))
+	}
+
+	for _, certificate := range certificates {
+		if len(certificate.Hosts) == 0 {
+			continue
+		}
+		policies = append(policies, NewCertificateSelectionPolicy(certificate.Hosts, certificate.SelectionTag()))
+	}
+
+	if len(policies) == 0 {
+		return nil
+	}
+	return append(policies, TLSConnectionPolicy{})
+}
@@ -0,0 +1,43 @@
+package caddy
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+func TestNewTLSAppWithInternalWildcardCertificates(t *testing.T) {
+	tlsApp := newTLSApp(discovery.TLSConfig{
+		Internal: discovery.InternalConfig{
+			BaseDomains: []string{"localhost", "test"},
+			Wildcard:    true,
+		},
+		Policies: []discovery.AutomationPolicyConfig{{Subjects: []string{"example.com"}}},
+	})
+
+	if tlsApp == nil || tlsApp.Automation == nil {
+		t.Fatalf("Expected tls automation, got none")
+	}
+	policies := tlsApp.Automation.Policies
+	if len(policies) != 2 {
+		t.Fatalf("Expected 2 policies, got %d", len(policies))
+	}
+	expectedSubjects := []string{"localhost", "*.localhost", "test", "*.test"}
+	if !slices.Equal(policies[0].Subjects, expectedSubjects) {
+		t.Errorf("Expected subjects %v, got %v", expectedSubjects, policies[0].Subjects)
+	}
+	if policies[0].Issuers[0].Module != "internal" {
+		t.Errorf("Expected internal issuer, got %s", policies[0].Issuers[0].Module)
+	}
+	expectedAutomate := []string{"*.localhost", "*.test"}
+	if !slices.Equal(tlsApp.Certificates.Automate, expectedAutomate) {
+		t.Errorf("Expected automated names %v, got %v", expectedAutomate, tlsApp.Certificates.Automate)
+	}
+}
+
+func TestNewTLSAppWithoutConfigurationUsesCaddyDefaults(t *testing.T) {
+	if tlsApp := newTLSApp(discovery.TLSConfig{}); tlsApp != nil {
+		t.Errorf("Expected no tls app, got %+v", tlsApp)
+	}
+}
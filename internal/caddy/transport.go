@@ -0,0 +1,62 @@
+package caddy
+
+import (
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// newTransport creates the http transport of a reverse proxy, nil if Caddy's default transport should be used
+func newTransport(options discovery.RouteOptions) *Transport {
+	transportConfig := options.Transport
+	if !options.UpstreamTLS.IsEnabled() && transportConfig.IsZero() {
+		return nil
+	}
+
+	transport := &Transport{
+		Protocol:              "http",
+		DialTimeout:           formatDuration(transportConfig.DialTimeout),
+		ResponseHeaderTimeout: formatDuration(transportConfig.ResponseHeaderTimeout),
+		ReadTimeout:           formatDuration(transportConfig.ReadTimeout),
+		WriteTimeout:          formatDuration(transportConfig.WriteTimeout),
+		Versions:              transportConfig.Versions,
+		MaxConnsPerHost:       transportConfig.MaxConnsPerHost,
+	}
+	if options.UpstreamTLS.IsEnabled() {
+		transport.TLS = newTransportTLS(options.UpstreamTLS)
+	}
+
+	keepAliveConfig := transportConfig.KeepAlive
+	if keepAliveConfig != (discovery.KeepAliveConfig{}) {
+		transport.KeepAlive = &KeepAlive{
+			IdleTimeout:         formatDuration(keepAliveConfig.IdleTimeout),
+			MaxIdleConnsPerHost: keepAliveConfig.MaxIdleConnsPerHost,
+		}
+		if keepAliveConfig.Disabled {
+			enabled := false
+			transport.KeepAlive.Enabled = &enabled
+		}
+	}
+	return transport
+}
+
+func newTransportTLS(upstreamTLS discovery.UpstreamTLSConfig) *TransportTLS {
+	transportTLS := &TransportTLS{
+		ServerName:               upstreamTLS.ServerName,
+		ClientCertificateFile:    upstreamTLS.CertFilePath,
+		ClientCertificateKeyFile: upstreamTLS.KeyFilePath,
+		InsecureSkipVerify:       upstreamTLS.InsecureSkipVerify,
+	}
+	if upstreamTLS.CAFilePath != "" {
+		transportTLS.RootCAPEMFiles = []string{upstreamTLS.CAFilePath}
+	}
+	return transportTLS
+}
+
+// formatDuration formats a duration in the notation of Caddy's JSON config, empty for unset durations
+func formatDuration(duration time.Duration) string {
+	if duration <= 0 {
+		return ""
+	}
+	return duration.String()
+}
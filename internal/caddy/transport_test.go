@@ -0,0 +1,51 @@
+package caddy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+func TestNewTransportUsesCaddyDefaults(t *testing.T) {
+	if transport := newTransport(discovery.RouteOptions{}); transport != nil {
+		t.Errorf("Expected no transport, got %+v", transport)
+	}
+}
+
+func TestNewTransportConfiguresTimeoutsAndVersions(t *testing.T) {
+	transport := newTransport(discovery.RouteOptions{
+		Transport: discovery.TransportConfig{
+			DialTimeout:           5 * time.Second,
+			ResponseHeaderTimeout: 2 * time.Minute,
+			KeepAlive:             discovery.KeepAliveConfig{Disabled: true},
+			Versions:              []string{"h2c", "2"},
+			MaxConnsPerHost:       10,
+		},
+	})
+
+	content, err := json.Marshal(transport)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"protocol":"http","dial_timeout":"5s","response_header_timeout":"2m0s",` +
+		`"keep_alive":{"enabled":false},"versions":["h2c","2"],"max_conns_per_host":10}`
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+}
+
+func TestNewTransportConfiguresUpstreamTLS(t *testing.T) {
+	transport := newTransport(discovery.RouteOptions{
+		UpstreamTLS: discovery.UpstreamTLSConfig{InsecureSkipVerify: true},
+		Transport:   discovery.TransportConfig{ReadTimeout: 10 * time.Minute},
+	})
+
+	if transport == nil || transport.TLS == nil || !transport.TLS.InsecureSkipVerify {
+		t.Fatalf("Expected insecure upstream TLS, got %+v", transport)
+	}
+	if transport.ReadTimeout != "10m0s" {
+		t.Errorf("Expected read timeout 10m0s, got %s", transport.ReadTimeout)
+	}
+}
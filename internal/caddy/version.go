@@ -0,0 +1,92 @@
+package caddy
+
+import (
+	"fmt"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// VersionedUpstream is one of several upstreams serving a domain
+type VersionedUpstream struct {
+	Dial    string
+	Version string
+	Weight  int
+}
+
+// NewVersionedServiceRoute creates a reverse proxy balancing the accesses to incomingDomain between the upstreams.
+// If weighted, upstreams are selected by weighted round robin and upstreams with weight 0 get no requests.
+// Requests whose version header or cookie of the route options names a version are sent to the upstreams
+// of that version only, regardless of their weights.
+func NewVersionedServiceRoute(incomingDomain string, upstreams []VersionedUpstream, weighted bool, options discovery.RouteOptions) Route {
+	var routes []Route
+	var versions []string
+	versionUpstreams := make(map[string][]string)
+	for _, upstream := range upstreams {
+		if upstream.Version == "" {
+			continue
+		}
+		if _, ok := versionUpstreams[upstream.Version]; !ok {
+			versions = append(versions, upstream.Version)
+		}
+		versionUpstreams[upstream.Version] = append(versionUpstreams[upstream.Version], upstream.Dial)
+	}
+	for _, version := range versions {
+		match := newVersionMatch(options.Version, version)
+		if len(match) == 0 {
+			break
+		}
+		routes = append(routes, Route{
+			Match:  match,
+			Handle: []Handle{newProxyHandle(versionUpstreams[version], nil, options)},
+		})
+	}
+
+	var dials []string
+	var weights []int
+	for _, upstream := range upstreams {
+		if weighted && upstream.Weight == 0 {
+			continue
+		}
+		dials = append(dials, upstream.Dial)
+		if weighted {
+			weights = append(weights, upstream.Weight)
+		}
+	}
+	routes = append(routes, Route{
+		Match:  nil,
+		Handle: []Handle{newProxyHandle(dials, weights, options)},
+	})
+
+	return newRoute(incomingDomain, options, nil, routes...)
+}
+
+// newVersionMatch matches requests pinned to the version by the version header or cookie, any of them matches
+func newVersionMatch(config discovery.VersionConfig, version string) []Match {
+	var match []Match
+	if config.Header != "" {
+		match = append(match, Match{Header: map[string][]string{config.Header: {version}}})
+	}
+	if config.Cookie != "" {
+		match = append(match, Match{Expression: fmt.Sprintf("{http.request.cookie.%s} == '%s'", config.Cookie, version)})
+	}
+	return match
+}
+
+// newProxyHandle creates a reverse proxy to the upstreams with the transport and request headers of the route options.
+// Several upstreams are selected by the load balancing policy of the route options and the optional weights.
+func newProxyHandle(dials []string, weights []int, options discovery.RouteOptions) Handle {
+	upstreams := make([]Upstream, 0, len(dials))
+	for _, dial := range dials {
+		upstreams = append(upstreams, Upstream{Dial: dial})
+	}
+	handle := Handle{
+		Handler:   "reverse_proxy",
+		Upstreams: upstreams,
+		Transport: newTransport(options),
+		Headers:   newProxyHeaders(options.Headers),
+	}
+	if len(upstreams) > 1 {
+		handle.LoadBalancing = newLoadBalancing(options.LoadBalancing, weights)
+	}
+	return handle
+}
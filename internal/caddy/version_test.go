@@ -0,0 +1,55 @@
+package caddy
+
+import (
+	"testing"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+func TestNewVersionedServiceRouteWeightsUpstreams(t *testing.T) {
+	route := NewVersionedServiceRoute("app.example.com", []VersionedUpstream{
+		{Dial: "10.0.0.1:8080", Version: "stable", Weight: 9},
+		{Dial: "10.0.0.2:8080", Version: "canary", Weight: 1},
+		{Dial: "10.0.0.3:8080", Version: "next", Weight: 0},
+	}, true, discovery.RouteOptions{})
+
+	subroutes := route.Handle[0].Routes
+	if len(subroutes) != 1 {
+		t.Fatalf("Expected only the balanced route without pinning, got %d routes", len(subroutes))
+	}
+	proxy := subroutes[0].Handle[0]
+	if len(proxy.Upstreams) != 2 || proxy.Upstreams[1].Dial != "10.0.0.2:8080" {
+		t.Errorf("Expected the upstreams without weight 0, got %+v", proxy.Upstreams)
+	}
+	policy := proxy.LoadBalancing.SelectionPolicy
+	if policy.Policy != "weighted_round_robin" || len(policy.Weights) != 2 || policy.Weights[0] != 9 || policy.Weights[1] != 1 {
+		t.Errorf("Expected weighted_round_robin with weights 9 and 1, got %+v", policy)
+	}
+}
+
+func TestNewVersionedServiceRoutePinsVersions(t *testing.T) {
+	route := NewVersionedServiceRoute("app.example.com", []VersionedUpstream{
+		{Dial: "10.0.0.1:8080", Version: "blue", Weight: 1},
+		{Dial: "10.0.0.2:8080", Version: "green", Weight: 1},
+	}, false, discovery.RouteOptions{
+		Version: discovery.VersionConfig{Header: "X-Version", Cookie: "version"},
+	})
+
+	subroutes := route.Handle[0].Routes
+	if len(subroutes) != 3 {
+		t.Fatalf("Expected a pinned route per version and the balanced route, got %d routes", len(subroutes))
+	}
+	green := subroutes[1]
+	if len(green.Match) != 2 || green.Match[0].Header["X-Version"][0] != "green" {
+		t.Errorf("Expected header match for green, got %+v", green.Match)
+	}
+	if expression := green.Match[1].Expression; expression != "{http.request.cookie.version} == 'green'" {
+		t.Errorf("Expected cookie match for green, got %s", expression)
+	}
+	if upstreams := green.Handle[0].Upstreams; len(upstreams) != 1 || upstreams[0].Dial != "10.0.0.2:8080" {
+		t.Errorf("Expected the green upstream, got %+v", upstreams)
+	}
+	if balanced := subroutes[2].Handle[0]; len(balanced.Upstreams) != 2 || balanced.LoadBalancing != nil {
+		t.Errorf("Expected both upstreams without weights, got %+v", balanced)
+	}
+}
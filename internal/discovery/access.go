@@ -0,0 +1,40 @@
+package discovery
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// AccessConfig holds the client addresses or CIDR ranges allowed or denied to access a route.
+// Denied clients get a 403 response, as do all clients not allowed if Allow is set.
+type AccessConfig struct {
+	Allow []string `mapstructure:"allow" yaml:"allow,omitempty"`
+	Deny  []string `mapstructure:"deny" yaml:"deny,omitempty"`
+}
+
+// IsRestricted reports whether the route restricts client addresses
+func (a AccessConfig) IsRestricted() bool {
+	return len(a.Allow) > 0 || len(a.Deny) > 0
+}
+
+// Validate checks that all entries are addresses or CIDR ranges, Caddy rejects the whole config otherwise
+func (a AccessConfig) Validate() error {
+	return ValidateIPRanges(append(append([]string(nil), a.Allow...), a.Deny...))
+}
+
+// ValidateIPRanges checks that all entries are ip addresses or CIDR ranges
+func ValidateIPRanges(ranges []string) error {
+	for _, ipRange := range ranges {
+		var err error
+		if strings.Contains(ipRange, "/") {
+			_, err = netip.ParsePrefix(ipRange)
+		} else {
+			_, err = netip.ParseAddr(ipRange)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid ip range %s: %w", ipRange, err)
+		}
+	}
+	return nil
+}
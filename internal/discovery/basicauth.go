@@ -0,0 +1,77 @@
+package discovery
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// BasicAuthConfig holds the accounts allowed to access a route protected by HTTP basic authentication
+type BasicAuthConfig struct {
+	// Users maps user names to bcrypt password hashes
+	Users map[string]string `mapstructure:"users" yaml:"users,omitempty" json:"-"`
+	// File is a htpasswd file with user:hash lines, read by the service discovery
+	File  string `mapstructure:"file" yaml:"file,omitempty"`
+	Realm string `mapstructure:"realm" yaml:"realm,omitempty"`
+}
+
+// IsEnabled reports whether the route requires authentication
+func (b BasicAuthConfig) IsEnabled() bool {
+	return len(b.Users) > 0 || b.File != ""
+}
+
+// Resolve returns the config with the accounts of the file merged into Users, users configured directly win.
+// All password hashes have to be bcrypt hashes.
+func (b BasicAuthConfig) Resolve() (BasicAuthConfig, error) {
+	users := make(map[string]string, len(b.Users))
+	if b.File != "" {
+		content, err := os.ReadFile(b.File)
+		if err != nil {
+			return BasicAuthConfig{}, err
+		}
+		fileUsers, err := ParseHtpasswd(string(content))
+		if err != nil {
+			return BasicAuthConfig{}, fmt.Errorf("invalid htpasswd file %s: %w", b.File, err)
+		}
+		for user, hash := range fileUsers {
+			users[user] = hash
+		}
+	}
+	for user, hash := range b.Users {
+		users[user] = hash
+	}
+
+	if len(users) == 0 {
+		return BasicAuthConfig{}, fmt.Errorf("no basic auth accounts configured")
+	}
+	for user, hash := range users {
+		if !isBcryptHash(hash) {
+			return BasicAuthConfig{}, fmt.Errorf("password of user %s is not a bcrypt hash", user)
+		}
+	}
+
+	return BasicAuthConfig{Users: users, Realm: b.Realm}, nil
+}
+
+// ParseHtpasswd parses user:hash lines, empty lines and lines starting with # are skipped
+func ParseHtpasswd(content string) (map[string]string, error) {
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(entry, ":")
+		if !ok || user == "" || hash == "" {
+			return nil, fmt.Errorf("line %d is not a user:hash entry", line)
+		}
+		users[user] = hash
+	}
+	return users, scanner.Err()
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
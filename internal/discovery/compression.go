@@ -0,0 +1,36 @@
+package discovery
+
+// CompressionConfig configures the compression of responses
+type CompressionConfig struct {
+	// Enabled enables compression, routes opt in or out if set, otherwise the global setting applies
+	Enabled *bool `mapstructure:"enabled" yaml:"enabled,omitempty"`
+	// Encodings are the supported encodings in order of preference, zstd and gzip if empty
+	Encodings []string `mapstructure:"encodings" yaml:"encodings,omitempty"`
+	// MinimumLength is the minimum response size in bytes to compress, Caddy's default is used if zero
+	MinimumLength int `mapstructure:"minimumLength" yaml:"minimumLength,omitempty"`
+	// ContentTypes are the compressed content types like text/* or application/json*, Caddy's defaults if empty
+	ContentTypes []string `mapstructure:"contentTypes" yaml:"contentTypes,omitempty"`
+}
+
+// Merge returns the global compression config c overridden by the settings of a route
+func (c CompressionConfig) Merge(route CompressionConfig) CompressionConfig {
+	merged := c
+	if route.Enabled != nil {
+		merged.Enabled = route.Enabled
+	}
+	if len(route.Encodings) > 0 {
+		merged.Encodings = route.Encodings
+	}
+	if route.MinimumLength > 0 {
+		merged.MinimumLength = route.MinimumLength
+	}
+	if len(route.ContentTypes) > 0 {
+		merged.ContentTypes = route.ContentTypes
+	}
+	return merged
+}
+
+// IsEnabled reports whether responses are compressed
+func (c CompressionConfig) IsEnabled() bool {
+	return c.Enabled != nil && *c.Enabled
+}
@@ -1,23 +1,372 @@
 package discovery
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
 
 type CaddyConfig struct {
-	ManualRoutes  []ManualRoute `yaml:"routes"`
-	TLSConfig     TLSConfig
+	ManualRoutes []ManualRoute `yaml:"routes"`
+	TLSConfig    TLSConfig
+	// CaddyAdminUrl is the admin url of the Caddy instance a connector talks to
 	CaddyAdminUrl string
+	// CaddyAdminUrls holds the admin urls of all Caddy instances that are kept in sync
+	CaddyAdminUrls []string
+	Admin          AdminConfig
+	Retry          RetryConfig
+	Watchdog       WatchdogConfig
+	Servers        []ServerConfig
+	Api            ApiConfig
+	// Headers are header operations applied to every route before the operations of the route
+	Headers HeadersConfig
+	// ForwardAuth is the auth service used by routes with forward auth, enabled globally or per route
+	ForwardAuth ForwardAuthConfig
+	// Compression configures the compression of all routes, routes opt in or out and override settings
+	Compression CompressionConfig
+	// CacheControl is the Cache-Control header of all routes without their own
+	CacheControl string
+	// Fallback is the response for requests without a matching route, a 404 Not Found if unset
+	Fallback PageConfig
+	// ErrorPages replace Caddy's error responses of all managed servers
+	ErrorPages ErrorPagesConfig
+	// Maintenance configures the maintenance page and puts all routes into maintenance if enabled
+	Maintenance MaintenanceConfig
+	// TrustedProxies are the addresses or CIDR ranges of proxies in front of Caddy whose X-Forwarded-For
+	// headers determine the client address used by access restrictions
+	TrustedProxies []string
+	// CertificateCheck configures how the certificates served by Caddy are checked for the status report
+	CertificateCheck CertificateCheckConfig
 }
 
+type CertificateCheckConfig struct {
+	// Address is the TLS listener of Caddy dialed with the SNI of each host, derived from the listen
+	// addresses of the host's server if empty
+	Address string `mapstructure:"address"`
+	// Host is the host the listeners of Caddy are dialed at, the listen host or the admin api host if empty
+	Host    string        `mapstructure:"host"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// ApiConfig configures the http api served by the service discovery
+type ApiConfig struct {
+	// Listen is the address of the http api, the api is disabled if empty
+	Listen string `mapstructure:"listen"`
+	// Token authenticates requests changing the state as bearer token, these requests are rejected without token
+	Token string `mapstructure:"token" json:"-"`
+}
+
+// DefaultServerName is the name of the Caddy server used if no servers are configured
+const DefaultServerName = "srv0"
+
+// ServerConfig describes a Caddy server managed by the service discovery
+type ServerConfig struct {
+	Name   string   `mapstructure:"name"`
+	Listen []string `mapstructure:"listen"`
+}
+
+// Scheme returns the scheme the server is reached with, http if it only listens on Caddy's HTTP port 80
+func (s ServerConfig) Scheme() string {
+	if len(s.Listen) == 0 {
+		return "https"
+	}
+	for _, listen := range s.Listen {
+		// strip the network of addresses like tcp/:80
+		if _, address, ok := strings.Cut(listen, "/"); ok {
+			listen = address
+		}
+		if _, port, err := net.SplitHostPort(listen); err != nil || port != "80" {
+			return "https"
+		}
+	}
+	return "http"
+}
+
+const (
+	// RouteTypeProxy routes forward requests to the upstream, it is the default type
+	RouteTypeProxy = "proxy"
+	// RouteTypeRedirect routes redirect requests to RedirectTo, keeping path and query
+	RouteTypeRedirect = "redirect"
+	// RouteTypeStatic routes serve the files of a directory
+	RouteTypeStatic = "static"
+)
+
 type ManualRoute struct {
 	Domain   string `yaml:"domain"`
-	Upstream string `yaml:"upstreamUrl"`
+	Type     string `yaml:"type,omitempty"`
+	Upstream string `yaml:"upstream"`
 	TLS      bool   `yaml:"tls"`
+	// RedirectTo is the host or url redirect routes redirect to
+	RedirectTo string `yaml:"redirectTo,omitempty"`
+	// Static configures the files served by static routes
+	Static       StaticConfig `yaml:"static,omitempty"`
+	RouteOptions `mapstructure:",squash" yaml:",inline"`
+}
+
+type StaticConfig struct {
+	// Root is the directory the files are served from, as seen by Caddy
+	Root string `mapstructure:"root" yaml:"root,omitempty"`
+	// IndexNames are the files served for directories, Caddy uses index.html and index.txt if empty
+	IndexNames []string `mapstructure:"indexNames" yaml:"indexNames,omitempty"`
+	// SPA serves /index.html for paths without a file, so single page applications can route on the client
+	SPA bool `mapstructure:"spa" yaml:"spa,omitempty"`
+}
+
+// RouteOptions holds the settings of a single route shared by manual routes and discovered services
+type RouteOptions struct {
+	// Server is the name of the Caddy server serving the route, the first managed server is used if empty
+	Server string `mapstructure:"server" yaml:"server,omitempty"`
+	// Certificate is the tag of a configured certificate served for the route's domain
+	Certificate string `mapstructure:"certificate" yaml:"certificate,omitempty"`
+	// UpstreamTLS configures TLS connections from Caddy to the upstream
+	UpstreamTLS UpstreamTLSConfig `mapstructure:"upstreamTls" yaml:"upstreamTls,omitempty"`
+	// Transport tunes the HTTP connections from Caddy to the upstream
+	Transport TransportConfig `mapstructure:"transport" yaml:"transport,omitempty"`
+	// Headers manipulates the request headers sent to the upstream and the response headers sent to the client
+	Headers HeadersConfig `mapstructure:"headers" yaml:"headers,omitempty"`
+	// BasicAuth protects the route with HTTP basic authentication
+	BasicAuth BasicAuthConfig `mapstructure:"basicAuth" yaml:"basicAuth,omitempty"`
+	// ForwardAuth protects the route with an external auth service, merged with the global forward auth config
+	ForwardAuth ForwardAuthConfig `mapstructure:"forwardAuth" yaml:"forwardAuth,omitempty"`
+	// Access restricts the client addresses allowed to access the route
+	Access AccessConfig `mapstructure:"access" yaml:"access,omitempty"`
+	// Aliases are hosts redirected to the domain of the route
+	Aliases []string `mapstructure:"aliases" yaml:"aliases,omitempty"`
+	// RedirectStatus is the status code of redirects, 301 if unset
+	RedirectStatus int `mapstructure:"redirectStatus" yaml:"redirectStatus,omitempty"`
+	// Compression compresses responses, merged with the global compression config
+	Compression CompressionConfig `mapstructure:"compression" yaml:"compression,omitempty"`
+	// CacheControl is set as Cache-Control header of all responses, replacing the header of the upstream
+	CacheControl string `mapstructure:"cacheControl" yaml:"cacheControl,omitempty"`
+	// Maintenance serves the maintenance page instead of the upstream
+	Maintenance bool `mapstructure:"maintenance" yaml:"maintenance,omitempty"`
+	// Version groups the discovered endpoints of a domain into weighted versions
+	Version VersionConfig `mapstructure:"version" yaml:"version,omitempty"`
+	// LoadBalancing selects the upstream of requests to a domain served by several endpoints, e.g. sticky sessions
+	LoadBalancing LoadBalancingConfig `mapstructure:"loadBalancing" yaml:"loadBalancing,omitempty"`
+}
+
+type HeadersConfig struct {
+	Request  HeaderOpsConfig `mapstructure:"request" yaml:"request,omitempty"`
+	Response HeaderOpsConfig `mapstructure:"response" yaml:"response,omitempty"`
+}
+
+// HeaderOpsConfig holds header operations, values may contain Caddy placeholders like {http.request.remote.host}
+type HeaderOpsConfig struct {
+	Set    map[string]string `mapstructure:"set" yaml:"set,omitempty"`
+	Add    map[string]string `mapstructure:"add" yaml:"add,omitempty"`
+	Delete []string          `mapstructure:"delete" yaml:"delete,omitempty"`
+}
+
+// Merge returns the global headers h combined with the headers of a route, route values win for set and add
+func (h HeadersConfig) Merge(route HeadersConfig) HeadersConfig {
+	return HeadersConfig{
+		Request:  h.Request.merge(route.Request),
+		Response: h.Response.merge(route.Response),
+	}
+}
+
+// IsZero reports whether no header operation is configured
+func (h HeadersConfig) IsZero() bool {
+	return h.Request.IsZero() && h.Response.IsZero()
+}
+
+func (o HeaderOpsConfig) merge(route HeaderOpsConfig) HeaderOpsConfig {
+	return HeaderOpsConfig{
+		Set:    mergeHeaderValues(o.Set, route.Set),
+		Add:    mergeHeaderValues(o.Add, route.Add),
+		Delete: append(append([]string(nil), o.Delete...), route.Delete...),
+	}
+}
+
+func (o HeaderOpsConfig) IsZero() bool {
+	return len(o.Set) == 0 && len(o.Add) == 0 && len(o.Delete) == 0
+}
+
+// mergeHeaderValues merges the header values with canonical names, as viper lower-cases configured keys
+func mergeHeaderValues(global map[string]string, route map[string]string) map[string]string {
+	if len(global) == 0 && len(route) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(global)+len(route))
+	for name, value := range global {
+		merged[http.CanonicalHeaderKey(name)] = value
+	}
+	for name, value := range route {
+		merged[http.CanonicalHeaderKey(name)] = value
+	}
+	return merged
+}
+
+// TransportConfig holds the HTTP transport settings of an upstream, zero values use Caddy's defaults
+type TransportConfig struct {
+	DialTimeout           time.Duration   `mapstructure:"dialTimeout" yaml:"dialTimeout,omitempty"`
+	ResponseHeaderTimeout time.Duration   `mapstructure:"responseHeaderTimeout" yaml:"responseHeaderTimeout,omitempty"`
+	ReadTimeout           time.Duration   `mapstructure:"readTimeout" yaml:"readTimeout,omitempty"`
+	WriteTimeout          time.Duration   `mapstructure:"writeTimeout" yaml:"writeTimeout,omitempty"`
+	KeepAlive             KeepAliveConfig `mapstructure:"keepAlive" yaml:"keepAlive,omitempty"`
+	// Versions are the HTTP versions used for the upstream, e.g. ["h2c", "2"] for gRPC without TLS
+	Versions        []string `mapstructure:"versions" yaml:"versions,omitempty"`
+	MaxConnsPerHost int      `mapstructure:"maxConnsPerHost" yaml:"maxConnsPerHost,omitempty"`
+}
+
+type KeepAliveConfig struct {
+	Disabled            bool          `mapstructure:"disabled" yaml:"disabled,omitempty"`
+	IdleTimeout         time.Duration `mapstructure:"idleTimeout" yaml:"idleTimeout,omitempty"`
+	MaxIdleConnsPerHost int           `mapstructure:"maxIdleConnsPerHost" yaml:"maxIdleConnsPerHost,omitempty"`
+}
+
+// IsZero reports whether no transport setting is configured
+func (t TransportConfig) IsZero() bool {
+	return t.DialTimeout == 0 && t.ResponseHeaderTimeout == 0 && t.ReadTimeout == 0 && t.WriteTimeout == 0 &&
+		t.KeepAlive == KeepAliveConfig{} && len(t.Versions) == 0 && t.MaxConnsPerHost == 0
+}
+
+// UpstreamTLSConfig holds the TLS settings Caddy uses to connect to an HTTPS upstream
+type UpstreamTLSConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled,omitempty"`
+	// ServerName overrides the SNI and the name the upstream certificate is verified against
+	ServerName string `mapstructure:"serverName" yaml:"serverName,omitempty"`
+	// CAFilePath is a PEM file with the root certificates trusted for the upstream instead of the system roots
+	CAFilePath string `mapstructure:"caFilePath" yaml:"caFilePath,omitempty"`
+	// CertFilePath and KeyFilePath are the client certificate presented to the upstream for mutual TLS
+	CertFilePath       string `mapstructure:"certFilePath" yaml:"certFilePath,omitempty"`
+	KeyFilePath        string `mapstructure:"keyFilePath" yaml:"keyFilePath,omitempty"`
+	InsecureSkipVerify bool   `mapstructure:"insecureSkipVerify" yaml:"insecureSkipVerify,omitempty"`
+}
+
+// IsEnabled reports whether the upstream is connected via TLS, which is implied by any TLS setting
+func (u UpstreamTLSConfig) IsEnabled() bool {
+	return u != UpstreamTLSConfig{}
 }
 
 type TLSConfig struct {
 	Manual       bool   `mapstructure:"manual"`
 	CertFilePath string `mapstructure:"certFilePath"`
 	KeyFilePath  string `mapstructure:"keyFilePath"`
+
+	// Certificates are additional certificate pairs, selected by their hosts or by routes referencing their tag
+	Certificates []CertificateConfig `mapstructure:"certificates"`
+	// CertificateFolders are directories of PEM files containing certificate and key, selected by SNI
+	CertificateFolders []string `mapstructure:"certificateFolders"`
+
+	OnDemand OnDemandConfig `mapstructure:"onDemand"`
+	Internal InternalConfig `mapstructure:"internal"`
+
+	// Automation is the automation policy used for all subjects that are not matched by Policies
+	Automation AutomationPolicyConfig   `mapstructure:"automation"`
+	Policies   []AutomationPolicyConfig `mapstructure:"policies"`
+}
+
+// OnDemandConfig enables on-demand TLS, certificates are obtained during the first TLS handshake
+// for domains the ask endpoint of the service discovery allows
+type OnDemandConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// AskUrl is the ask endpoint Caddy uses to check domains, derived from the api listen address if empty
+	AskUrl string `mapstructure:"askUrl"`
+}
+
+// InternalConfig issues the certificates of development domains (e.g. localhost or test) from Caddy's local CA
+type InternalConfig struct {
+	// BaseDomains are the domains whose subdomains use the internal issuer
+	BaseDomains []string `mapstructure:"baseDomains"`
+	// Wildcard serves a single wildcard certificate per base domain instead of one certificate per route
+	Wildcard bool `mapstructure:"wildcard"`
+}
+
+// CertificateConfig describes a certificate pair loaded from files
+type CertificateConfig struct {
+	CertFilePath string `mapstructure:"certFilePath"`
+	KeyFilePath  string `mapstructure:"keyFilePath"`
+	// Tag is referenced by routes selecting this certificate, the cert file path is used if empty
+	Tag string `mapstructure:"tag"`
+	// Hosts are always served with this certificate
+	Hosts []string `mapstructure:"hosts"`
+}
+
+// SelectionTag returns the tag used to select the certificate
+func (c CertificateConfig) SelectionTag() string {
+	if c.Tag != "" {
+		return c.Tag
+	}
+	return c.CertFilePath
+}
+
+// AutomationPolicyConfig configures how certificates for the subjects are obtained
+type AutomationPolicyConfig struct {
+	Subjects []string `mapstructure:"subjects"`
+	// Issuer is the issuer module, acme if empty, internal for Caddy's local CA
+	Issuer string `mapstructure:"issuer"`
+	Email  string `mapstructure:"email"`
+	// CA is the ACME directory url, e.g. of a local ACME server
+	CA string `mapstructure:"ca"`
+	// Staging uses the Let's Encrypt staging directory if no CA is set
+	Staging bool               `mapstructure:"staging"`
+	DNS     DNSChallengeConfig `mapstructure:"dns"`
+}
+
+// DNSChallengeConfig enables the DNS-01 challenge with a dns provider module compiled into Caddy
+type DNSChallengeConfig struct {
+	Provider  string            `mapstructure:"provider"`
+	Options   map[string]string `mapstructure:"options" json:"-"`
+	Resolvers []string          `mapstructure:"resolvers"`
+}
+
+// IsZero reports whether the policy does not configure anything
+func (p AutomationPolicyConfig) IsZero() bool {
+	return len(p.Subjects) == 0 && p.Issuer == "" && p.Email == "" && p.CA == "" && !p.Staging && p.DNS.Provider == ""
+}
+
+// AdminConfig configures how requests to the Caddy admin api are authenticated
+type AdminConfig struct {
+	// Headers are added to every request, e.g. for an authenticating proxy in front of the admin api
+	Headers     map[string]string `mapstructure:"headers" json:"-"`
+	BearerToken string            `mapstructure:"bearerToken" json:"-"`
+	// Origin is sent as Origin header and has to be listed in Caddy's admin origins if origin enforcement is enabled
+	Origin string         `mapstructure:"origin"`
+	TLS    AdminTLSConfig `mapstructure:"tls"`
+}
+
+type AdminTLSConfig struct {
+	CertFilePath string `mapstructure:"certFilePath"`
+	KeyFilePath  string `mapstructure:"keyFilePath"`
+	CAFilePath   string `mapstructure:"caFilePath"`
+	ServerName   string `mapstructure:"serverName"`
+}
+
+// RetryConfig controls timeouts, retries and the circuit breaker for requests to the Caddy admin api
+type RetryConfig struct {
+	RequestTimeout time.Duration `mapstructure:"requestTimeout"`
+	// MaxAttempts is the number of attempts with exponential backoff after connection errors and 5xx responses,
+	// including the first one. Later attempts are made every ReapplyInterval.
+	MaxAttempts    int           `mapstructure:"maxAttempts"`
+	InitialBackoff time.Duration `mapstructure:"initialBackoff"`
+	MaxBackoff     time.Duration `mapstructure:"maxBackoff"`
+	// FailureThreshold is the number of consecutive failed requests after which the circuit breaker opens
+	FailureThreshold int           `mapstructure:"failureThreshold"`
+	OpenDuration     time.Duration `mapstructure:"openDuration"`
+	// ReapplyInterval is the interval in which a desired state that could not be applied is retried after MaxAttempts
+	ReapplyInterval time.Duration `mapstructure:"reapplyInterval"`
+}
+
+// WatchdogConfig controls how often the Caddy instances are checked for a lost or drifted config
+type WatchdogConfig struct {
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// ManagedServers returns the configured servers or a single default server listening on :443 and :80
+func (c CaddyConfig) ManagedServers() []ServerConfig {
+	if len(c.Servers) > 0 {
+		return c.Servers
+	}
+	return []ServerConfig{{Name: DefaultServerName, Listen: []string{":443", ":80"}}}
+}
+
+// DefaultServer returns the name of the server used for routes that do not choose a server
+func (c CaddyConfig) DefaultServer() string {
+	return c.ManagedServers()[0].Name
 }
 
 func (c CaddyConfig) String() string {
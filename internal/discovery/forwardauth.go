@@ -0,0 +1,37 @@
+package discovery
+
+// ForwardAuthConfig configures an auth service every request is sent to before it reaches the upstream,
+// e.g. oauth2-proxy or Authelia
+type ForwardAuthConfig struct {
+	// Enabled enables forward auth, routes opt in or out if set, otherwise the global setting applies
+	Enabled *bool `mapstructure:"enabled" yaml:"enabled,omitempty"`
+	// Address is the upstream address of the auth service
+	Address string `mapstructure:"address" yaml:"address,omitempty"`
+	// Uri is the path and query of the auth endpoint
+	Uri string `mapstructure:"uri" yaml:"uri,omitempty"`
+	// CopyHeaders are the identity headers copied from a successful auth response to the request
+	CopyHeaders []string `mapstructure:"copyHeaders" yaml:"copyHeaders,omitempty"`
+}
+
+// Merge returns the global forward auth config f overridden by the settings of a route
+func (f ForwardAuthConfig) Merge(route ForwardAuthConfig) ForwardAuthConfig {
+	merged := f
+	if route.Enabled != nil {
+		merged.Enabled = route.Enabled
+	}
+	if route.Address != "" {
+		merged.Address = route.Address
+	}
+	if route.Uri != "" {
+		merged.Uri = route.Uri
+	}
+	if len(route.CopyHeaders) > 0 {
+		merged.CopyHeaders = route.CopyHeaders
+	}
+	return merged
+}
+
+// IsEnabled reports whether requests have to be authorized by the auth service
+func (f ForwardAuthConfig) IsEnabled() bool {
+	return f.Enabled != nil && *f.Enabled
+}
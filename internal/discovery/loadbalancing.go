@@ -0,0 +1,41 @@
+package discovery
+
+import "fmt"
+
+// Load balancing policies keeping the requests of a client on the same upstream
+const (
+	LoadBalancingCookie = "cookie"
+	// LoadBalancingIPHash hashes the address of the direct peer, which is the proxy in front of Caddy if there is one
+	LoadBalancingIPHash = "ip_hash"
+	// LoadBalancingClientIPHash hashes the client address, taken from the headers of trusted proxies
+	LoadBalancingClientIPHash = "client_ip_hash"
+	LoadBalancingHeader       = "header"
+	LoadBalancingURIHash      = "uri_hash"
+)
+
+// LoadBalancingConfig selects the upstream of a request if a domain is served by several endpoints
+type LoadBalancingConfig struct {
+	// Policy is cookie, ip_hash, client_ip_hash, header or uri_hash, Caddy's default random selection if empty
+	Policy string `mapstructure:"policy" yaml:"policy,omitempty"`
+	// CookieName is the name of the cookie of the cookie policy, Caddy uses lb if empty
+	CookieName string `mapstructure:"cookieName" yaml:"cookieName,omitempty"`
+	// CookieSecret signs the upstream stored in the cookie of the cookie policy
+	CookieSecret string `mapstructure:"cookieSecret" yaml:"cookieSecret,omitempty" json:"-"`
+	// Header is the request header whose value selects the upstream with the header policy
+	Header string `mapstructure:"header" yaml:"header,omitempty"`
+}
+
+// Validate checks the policy and that the header policy names a header
+func (l LoadBalancingConfig) Validate() error {
+	switch l.Policy {
+	case "", LoadBalancingCookie, LoadBalancingIPHash, LoadBalancingClientIPHash, LoadBalancingURIHash:
+		return nil
+	case LoadBalancingHeader:
+		if l.Header == "" {
+			return fmt.Errorf("header load balancing without header")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown load balancing policy %q", l.Policy)
+	}
+}
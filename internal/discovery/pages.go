@@ -0,0 +1,52 @@
+package discovery
+
+import (
+	"os"
+	"time"
+)
+
+// PageConfig configures a response page, the body may contain Caddy placeholders like {http.request.uuid}
+type PageConfig struct {
+	Status int    `mapstructure:"status"`
+	Body   string `mapstructure:"body"`
+	// File is read into the body when the configuration is loaded, e.g. an HTML page
+	File        string `mapstructure:"file"`
+	ContentType string `mapstructure:"contentType"`
+}
+
+// ErrorPagesConfig configures the pages served when a request fails, e.g. because an upstream is down
+type ErrorPagesConfig struct {
+	PageConfig `mapstructure:",squash"`
+	// StatusCodes are the error status codes answered with the page, 502, 503 and 504 if empty
+	StatusCodes []int `mapstructure:"statusCodes"`
+}
+
+// IsZero reports whether the page uses Caddy's defaults
+func (p PageConfig) IsZero() bool {
+	return p == PageConfig{}
+}
+
+// Load reads the file of the page into its body, HTML is assumed if no content type is configured
+func (p *PageConfig) Load() error {
+	if p.File == "" {
+		return nil
+	}
+	content, err := os.ReadFile(p.File)
+	if err != nil {
+		return err
+	}
+	p.Body = string(content)
+	if p.ContentType == "" {
+		p.ContentType = "text/html; charset=utf-8"
+	}
+	return nil
+}
+
+// MaintenanceConfig configures the maintenance page served instead of the upstream of routes in maintenance
+type MaintenanceConfig struct {
+	// Enabled puts all routes into maintenance
+	Enabled bool `mapstructure:"enabled"`
+	// RetryAfter is sent as Retry-After header, so clients know when to try again
+	RetryAfter time.Duration `mapstructure:"retryAfter"`
+	PageConfig `mapstructure:",squash"`
+}
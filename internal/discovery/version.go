@@ -0,0 +1,45 @@
+package discovery
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// versionNamePattern restricts version and cookie names, as they are used in Caddy expressions
+var versionNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// VersionConfig assigns a discovered endpoint to a version of its domain. Requests are balanced between the
+// endpoints of a domain by their weights, e.g. for canary releases and blue/green switches.
+type VersionConfig struct {
+	// Name is the version of the endpoint, e.g. v2 or green
+	Name string `mapstructure:"name" yaml:"name,omitempty"`
+	// Weight is the share of requests of the endpoint relative to the other endpoints of the domain.
+	// Endpoints without weight get 1, endpoints with weight 0 are only reached by pinned requests.
+	Weight *int `mapstructure:"weight" yaml:"weight,omitempty"`
+	// Header names a request header whose value pins the request to the version of that name
+	Header string `mapstructure:"header" yaml:"header,omitempty"`
+	// Cookie names a cookie whose value pins the request to the version of that name
+	Cookie string `mapstructure:"cookie" yaml:"cookie,omitempty"`
+}
+
+// EffectiveWeight returns the weight of the endpoint, 1 if unset
+func (v VersionConfig) EffectiveWeight() int {
+	if v.Weight == nil {
+		return 1
+	}
+	return *v.Weight
+}
+
+// Validate checks the names used in Caddy matchers and that the weight is not negative
+func (v VersionConfig) Validate() error {
+	if v.Name != "" && !versionNamePattern.MatchString(v.Name) {
+		return fmt.Errorf("invalid version name %q", v.Name)
+	}
+	if v.Cookie != "" && !versionNamePattern.MatchString(v.Cookie) {
+		return fmt.Errorf("invalid version cookie name %q", v.Cookie)
+	}
+	if v.Weight != nil && *v.Weight < 0 {
+		return fmt.Errorf("negative weight %d", *v.Weight)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package manager
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/caddy"
+)
+
+const (
+	// apiCommandTimeout limits how long api requests wait for the event loop, e.g. while it applies routes
+	apiCommandTimeout = 10 * time.Second
+	// apiReadHeaderTimeout, apiReadTimeout and apiIdleTimeout close connections of slow or idle clients
+	apiReadHeaderTimeout = 5 * time.Second
+	apiReadTimeout       = 10 * time.Second
+	apiIdleTimeout       = 60 * time.Second
+)
+
+// serveApi serves the http api of the service discovery until the server fails
+func (m *Manager) serveApi(listen string) {
+	slog.Info("Starting service discovery api", "listen", listen)
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           m.newApiHandler(),
+		ReadHeaderTimeout: apiReadHeaderTimeout,
+		ReadTimeout:       apiReadTimeout,
+		IdleTimeout:       apiIdleTimeout,
+	}
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Service discovery api stopped", "error", err)
+	}
+}
+
+func (m *Manager) newApiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ask", m.handleAsk)
+	mux.HandleFunc("GET /certificates", m.handleCertificates)
+	mux.HandleFunc("GET /instances", m.handleInstances)
+	mux.HandleFunc("GET /maintenance", m.handleGetMaintenance)
+	mux.HandleFunc("PUT /maintenance", m.requireToken(m.handleSetMaintenance(true)))
+	mux.HandleFunc("DELETE /maintenance", m.requireToken(m.handleSetMaintenance(false)))
+	mux.HandleFunc("PUT /maintenance/{domain}", m.requireToken(m.handleSetMaintenance(true)))
+	mux.HandleFunc("DELETE /maintenance/{domain}", m.requireToken(m.handleSetMaintenance(false)))
+	return mux
+}
+
+// requireToken only passes requests authenticated with the api token as bearer token. Without a configured
+// token the handler is disabled, as the api shares its listener with the ask endpoint reachable by Caddy.
+func (m *Manager) requireToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := m.config.Api.Token
+		if token == "" {
+			http.Error(w, "api token not configured", http.StatusForbidden)
+			return
+		}
+		bearerToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearerToken), []byte(token)) != 1 {
+			slog.Warn("Rejected unauthenticated api request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// runRequest runs the command of an api request on the event loop. It answers 503 and returns false
+// if the event loop did not start the command within apiCommandTimeout.
+func (m *Manager) runRequest(w http.ResponseWriter, r *http.Request, command func()) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), apiCommandTimeout)
+	defer cancel()
+
+	if err := m.run(ctx, command); err != nil {
+		slog.Warn("Api request timed out waiting for the event loop", "method", r.Method, "path", r.URL.Path, "error", err)
+		http.Error(w, "service discovery busy", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// handleAsk answers Caddy's on-demand TLS permission requests, certificates may only be obtained
+// for domains that are currently routed
+func (m *Manager) handleAsk(w http.ResponseWriter, r *http.Request) {
+	domain := strings.ToLower(r.URL.Query().Get("domain"))
+	if domain == "" {
+		http.Error(w, "missing domain", http.StatusBadRequest)
+		return
+	}
+
+	if !m.hasHost(domain) {
+		slog.Info("Denied on-demand certificate for unknown domain", "domain", domain)
+		http.Error(w, "domain not routed", http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleCertificates reports the certificates every Caddy instance serves for the routed hosts,
+// the instances are checked concurrently
+func (m *Manager) handleCertificates(w http.ResponseWriter, r *http.Request) {
+	var serverHosts map[string][]string
+	if !m.runRequest(w, r, func() {
+		serverHosts = m.routedServerHosts()
+	}) {
+		return
+	}
+
+	instanceStatuses := make([][]caddy.CertificateStatus, len(m.instances))
+	var wg sync.WaitGroup
+	for index, inst := range m.instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instanceStatuses[index] = inst.connector.CheckCertificates(serverHosts)
+		}()
+	}
+	wg.Wait()
+
+	statuses := make([]caddy.CertificateStatus, 0)
+	for _, instanceStatus := range instanceStatuses {
+		statuses = append(statuses, instanceStatus...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		slog.Error("Failed to write certificate report", "error", err)
+	}
+}
+
+// handleInstances reports which generation of the desired state every Caddy instance applied,
+// instances diverge while some of them lag behind
+func (m *Manager) handleInstances(w http.ResponseWriter, r *http.Request) {
+	var statuses []InstanceStatus
+	if !m.runRequest(w, r, func() {
+		statuses = m.instanceStatus()
+	}) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		slog.Error("Failed to write instance status", "error", err)
+	}
+}
+
+// handleGetMaintenance reports the maintenance switches set via the api
+func (m *Manager) handleGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var status MaintenanceStatus
+	if !m.runRequest(w, r, func() {
+		status = m.maintenance.status()
+	}) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		slog.Error("Failed to write maintenance status", "error", err)
+	}
+}
+
+// handleSetMaintenance switches maintenance of the domain in the path, or of all domains without domain,
+// and applies the changed routes. Clearing a switch restores the configured state of the routes.
+func (m *Manager) handleSetMaintenance(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		domain := r.PathValue("domain")
+		slog.Info("Switching maintenance via api", "domain", domain, "enabled", enabled)
+
+		if !m.runRequest(w, r, func() {
+			m.maintenance.set(domain, enabled)
+			m.updateRoutes()
+		}) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,184 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+	"github.com/jaku01/caddyservicediscovery/internal/provider"
+)
+
+func TestHandleAskAllowsOnlyRoutedDomains(t *testing.T) {
+	m := &Manager{}
+	m.setHosts(serverHosts(renderServers(&discovery.CaddyConfig{}, []provider.EndpointInfo{
+		{Domain: "app.example.com", Upstream: ":8080"},
+	}, maintenanceState{})))
+	handler := m.newApiHandler()
+
+	tests := map[string]int{
+		"/ask?domain=app.example.com":   http.StatusOK,
+		"/ask?domain=APP.example.com":   http.StatusOK,
+		"/ask?domain=other.example.com": http.StatusForbidden,
+		"/ask":                          http.StatusBadRequest,
+	}
+	for target, expectedStatus := range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		if recorder.Code != expectedStatus {
+			t.Errorf("Expected status %d for %s, got %d", expectedStatus, target, recorder.Code)
+		}
+	}
+}
+
+func TestHandleSetMaintenanceSwapsRoutes(t *testing.T) {
+	m := &Manager{
+		config:    &discovery.CaddyConfig{Api: discovery.ApiConfig{Token: "secret"}},
+		endpoints: []provider.EndpointInfo{{Domain: "app.example.com", Upstream: ":8080"}},
+		commands:  make(chan func()),
+	}
+	go func() {
+		for command := range m.commands {
+			command()
+		}
+	}()
+	defer close(m.commands)
+	handler := m.newApiHandler()
+
+	request := httptest.NewRequest(http.MethodPut, "/maintenance/APP.example.com", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, recorder.Code)
+	}
+	handles := m.servers[discovery.DefaultServerName].Routes[0].Handle[0].Routes[0].Handle
+	if last := handles[len(handles)-1]; last.Handler != "static_response" || last.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected maintenance page for app.example.com, got %+v", handles)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/maintenance", nil))
+	if body := strings.TrimSpace(recorder.Body.String()); body != `{"all":false,"domains":["app.example.com"]}` {
+		t.Errorf("Expected app.example.com in maintenance, got %s", body)
+	}
+
+	request = httptest.NewRequest(http.MethodDelete, "/maintenance/app.example.com", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	handles = m.servers[discovery.DefaultServerName].Routes[0].Handle[0].Routes[0].Handle
+	if handles[0].Handler != "reverse_proxy" {
+		t.Errorf("Expected restored reverse_proxy for app.example.com, got %+v", handles)
+	}
+}
+
+func TestMaintenanceApiRequiresToken(t *testing.T) {
+	tests := map[string]struct {
+		token          string
+		authorization  string
+		expectedStatus int
+	}{
+		"no token configured": {token: "", authorization: "Bearer ", expectedStatus: http.StatusForbidden},
+		"missing token":       {token: "secret", authorization: "", expectedStatus: http.StatusUnauthorized},
+		"wrong token":         {token: "secret", authorization: "Bearer guess", expectedStatus: http.StatusUnauthorized},
+		"basic auth":          {token: "secret", authorization: "Basic c2VjcmV0", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// no event loop runs, so authenticated requests would block
+			m := &Manager{config: &discovery.CaddyConfig{Api: discovery.ApiConfig{Token: test.token}}}
+			request := httptest.NewRequest(http.MethodPut, "/maintenance", nil)
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+
+			recorder := httptest.NewRecorder()
+			m.newApiHandler().ServeHTTP(recorder, request)
+			if recorder.Code != test.expectedStatus {
+				t.Errorf("Expected status %d, got %d", test.expectedStatus, recorder.Code)
+			}
+			if m.maintenance.All {
+				t.Errorf("Expected maintenance to stay disabled")
+			}
+		})
+	}
+}
+
+func TestApiAnswersServiceUnavailableWhileEventLoopIsBusy(t *testing.T) {
+	// nobody reads the commands, like an event loop blocked by a slow Caddy
+	m := &Manager{
+		config:   &discovery.CaddyConfig{Api: discovery.ApiConfig{Token: "secret"}},
+		commands: make(chan func(), 1),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	request := httptest.NewRequestWithContext(ctx, http.MethodPut, "/maintenance", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+
+	recorder := httptest.NewRecorder()
+	m.newApiHandler().ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d", http.StatusServiceUnavailable, recorder.Code)
+	}
+
+	// the queued command expired and is skipped once the event loop picks it up
+	(<-m.commands)()
+	if m.maintenance.All {
+		t.Errorf("Expected the expired maintenance switch to be skipped")
+	}
+}
+
+func TestHandleInstancesReportsDivergedInstances(t *testing.T) {
+	healthy := newFakeAdmin(t)
+	failing := newFakeAdmin(t)
+	failing.failing.Store(true)
+	m := newTestManager(t, discovery.RetryConfig{}, healthy, failing)
+	m.commands = make(chan func())
+	go func() {
+		for command := range m.commands {
+			command()
+		}
+	}()
+	defer close(m.commands)
+	m.updateRoutes()
+
+	recorder := httptest.NewRecorder()
+	m.newApiHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/instances", nil))
+
+	var statuses []InstanceStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(statuses) != 2 || !statuses[0].InSync || statuses[1].InSync || statuses[1].AdminUrl != failing.URL {
+		t.Errorf("Expected the failing instance to lag behind, got %+v", statuses)
+	}
+}
+
+func TestHandleAskAllowsSubdomainsOfWildcardHosts(t *testing.T) {
+	m := &Manager{}
+	m.setHosts(serverHosts(renderServers(&discovery.CaddyConfig{}, []provider.EndpointInfo{
+		{Domain: "*.apps.example.com", Upstream: ":8080"},
+	}, maintenanceState{})))
+	handler := m.newApiHandler()
+
+	tests := map[string]int{
+		"/ask?domain=shop.apps.example.com":   http.StatusOK,
+		"/ask?domain=a.shop.apps.example.com": http.StatusForbidden,
+		"/ask?domain=apps.example.com":        http.StatusForbidden,
+		"/ask?domain=shop.other.example.com":  http.StatusForbidden,
+		"/ask?domain=*.apps.example.com":      http.StatusOK,
+	}
+	for target, expectedStatus := range tests {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		if recorder.Code != expectedStatus {
+			t.Errorf("Expected status %d for %s, got %d", expectedStatus, target, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package manager
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/caddy"
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// instance tracks which generation of the desired state was applied to a single Caddy instance
+type instance struct {
+	connector *caddy.Connector
+	// retry configures how failed attempts are retried
+	retry discovery.RetryConfig
+
+	configCreated     bool
+	appliedGeneration uint64
+	lastError         error
+	lastApplied       time.Time
+	// failures counts the consecutive failed attempts, retryAt is the time of the next attempt
+	failures int
+	retryAt  time.Time
+	// rejectedGeneration is the generation Caddy rejected, it is not retried until the next generation
+	rejectedGeneration uint64
+}
+
+// InstanceStatus describes the synchronisation state of a single Caddy instance
+type InstanceStatus struct {
+	AdminUrl          string    `json:"adminUrl"`
+	AppliedGeneration uint64    `json:"appliedGeneration"`
+	InSync            bool      `json:"inSync"`
+	LastApplied       time.Time `json:"lastApplied"`
+	LastError         string    `json:"lastError,omitempty"`
+}
+
+func newInstances(caddyConnectors []*caddy.Connector, retry discovery.RetryConfig) []*instance {
+	instances := make([]*instance, 0, len(caddyConnectors))
+	for _, connector := range caddyConnectors {
+		instances = append(instances, &instance{connector: connector, retry: retry})
+	}
+	return instances
+}
+
+// apply writes every server to the instance, creating the caddy config first if this did not succeed yet
+func (i *instance) apply(servers map[string]caddy.Server, generation uint64) bool {
+	if !i.configCreated {
+		if err := i.connector.CreateCaddyConfig(); err != nil {
+			i.failed(err, generation, "Failed to create caddy config")
+			return false
+		}
+		i.configCreated = true
+	}
+
+	for serverName, server := range servers {
+		if err := i.connector.SetServer(serverName, server); err != nil {
+			i.failed(err, generation, "Failed to apply routes to caddy", "server", serverName)
+			return false
+		}
+	}
+
+	i.appliedGeneration = generation
+	i.lastError = nil
+	i.lastApplied = time.Now()
+	i.failures = 0
+	i.retryAt = time.Time{}
+	return true
+}
+
+// failed records and logs a failed attempt. Retryable failures schedule the next attempt, the first MaxAttempts-1
+// retries back off exponentially with jitter, later ones wait the reapply interval. Rejected generations are
+// not retried, as they would be rejected again, the instance lags behind until the next generation.
+func (i *instance) failed(err error, generation uint64, message string, args ...any) {
+	i.lastError = err
+	args = append([]any{"instance", i.connector.Config.CaddyAdminUrl}, append(args, "error", err)...)
+	if !caddy.IsRetryable(err) {
+		slog.Error(message+", caddy rejected the desired state, waiting for the next change", args...)
+		i.rejectedGeneration = generation
+		i.failures = 0
+		i.retryAt = time.Time{}
+		return
+	}
+
+	slog.Error(message+", queuing desired state", args...)
+	i.failures++
+
+	delay := i.retry.ReapplyInterval
+	if delay <= 0 {
+		delay = defaultReapplyInterval
+	}
+	if i.failures < i.retry.MaxAttempts {
+		delay = i.connector.Backoff(i.failures)
+	}
+	i.retryAt = time.Now().Add(delay)
+}
+
+// retrying reports whether the instance lags behind the generation and waits for a retry,
+// instances that rejected the generation wait for the next one instead
+func (i *instance) retrying(generation uint64) bool {
+	return i.appliedGeneration != generation && i.rejectedGeneration != generation
+}
+
+// retryDue reports whether the next attempt of a lagging instance is due at now
+func (i *instance) retryDue(now time.Time) bool {
+	return !now.Before(i.retryAt)
+}
+
+// check compares the managed servers of the instance with the desired servers and resets the instance
+// if Caddy lost its config (e.g. after a restart without --resume) or the servers drifted.
+// Reset instances are lagging behind and get the desired state applied again. If the config can not be
+// read, the instance is only marked as lagging, so an unreachable Caddy does not get its whole config replaced.
+func (i *instance) check(fingerprints map[string]string) {
+	if !i.configCreated {
+		return
+	}
+
+	config, err := i.connector.GetCaddyConfig()
+	if errors.Is(err, caddy.ErrNoCaddyConfig) {
+		slog.Warn("Watchdog found no caddy config, recreating it", "instance", i.connector.Config.CaddyAdminUrl)
+		i.reset(true)
+		return
+	}
+	if err != nil {
+		slog.Warn("Watchdog could not read caddy config, marking instance as lagging",
+			"instance", i.connector.Config.CaddyAdminUrl, "error", err)
+		i.lastError = err
+		i.reset(false)
+		return
+	}
+
+	for serverName, fingerprint := range fingerprints {
+		server, ok := config.Apps.HTTP.Servers[serverName]
+		if !ok {
+			slog.Warn("Watchdog found no managed server, recreating caddy config",
+				"instance", i.connector.Config.CaddyAdminUrl, "server", serverName)
+			i.reset(true)
+			return
+		}
+
+		actualFingerprint, err := caddy.Fingerprint(server)
+		if err != nil {
+			slog.Error("Watchdog could not fingerprint caddy server",
+				"instance", i.connector.Config.CaddyAdminUrl, "server", serverName, "error", err)
+			return
+		}
+		if actualFingerprint != fingerprint {
+			slog.Warn("Watchdog detected drifted server, syncing servers",
+				"instance", i.connector.Config.CaddyAdminUrl, "server", serverName,
+				"expected", fingerprint, "actual", actualFingerprint)
+			i.reset(false)
+			return
+		}
+	}
+}
+
+// reset marks the instance as lagging and due, recreateConfig also recreates the whole caddy config
+func (i *instance) reset(recreateConfig bool) {
+	i.appliedGeneration = 0
+	i.rejectedGeneration = 0
+	i.retryAt = time.Time{}
+	if recreateConfig {
+		i.configCreated = false
+	}
+}
+
+func (i *instance) status(generation uint64) InstanceStatus {
+	status := InstanceStatus{
+		AdminUrl:          i.connector.Config.CaddyAdminUrl,
+		AppliedGeneration: i.appliedGeneration,
+		InSync:            i.appliedGeneration == generation,
+		LastApplied:       i.lastApplied,
+	}
+	if i.lastError != nil {
+		status.LastError = i.lastError.Error()
+	}
+	return status
+}
+
+// checkInstances runs the watchdog check for all given instances in parallel
+func checkInstances(instances []*instance, fingerprints map[string]string) {
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inst.check(fingerprints)
+		}()
+	}
+	wg.Wait()
+}
+
+// applyToInstances applies the servers to all given instances in parallel, so a single unreachable
+// instance does not delay the others, and returns the number of instances that were updated
+func applyToInstances(instances []*instance, servers map[string]caddy.Server, generation uint64) int {
+	var wg sync.WaitGroup
+	results := make([]bool, len(instances))
+	for index, inst := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[index] = inst.apply(servers, generation)
+		}()
+	}
+	wg.Wait()
+
+	applied := 0
+	for _, ok := range results {
+		if ok {
+			applied++
+		}
+	}
+	return applied
+}
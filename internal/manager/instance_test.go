@@ -0,0 +1,239 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/caddy"
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+	"github.com/jaku01/caddyservicediscovery/internal/provider"
+)
+
+// fakeAdmin is a Caddy admin api counting its requests, failing them with 503 while failing is set
+// and rejecting them with 400 while rejecting is set. GET /config/ returns the stored config, null if none is stored.
+type fakeAdmin struct {
+	*httptest.Server
+	requests  atomic.Int32
+	failing   atomic.Bool
+	rejecting atomic.Bool
+	config    atomic.Pointer[caddy.Config]
+}
+
+func newFakeAdmin(t *testing.T) *fakeAdmin {
+	admin := &fakeAdmin{}
+	admin.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admin.requests.Add(1)
+		if admin.failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if admin.rejecting.Load() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodGet && r.URL.Path == "/config/" {
+			_ = json.NewEncoder(w).Encode(admin.config.Load())
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(admin.Close)
+	return admin
+}
+
+// newTestManager creates a manager for the admin apis with a single rendered endpoint
+func newTestManager(t *testing.T, retry discovery.RetryConfig, admins ...*fakeAdmin) *Manager {
+	var connectors []*caddy.Connector
+	for _, admin := range admins {
+		connector, err := caddy.NewConnector(discovery.CaddyConfig{CaddyAdminUrl: admin.URL, Retry: retry})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		connectors = append(connectors, connector)
+	}
+	return &Manager{
+		config:    connectors[0].Config,
+		instances: newInstances(connectors, retry),
+		endpoints: []provider.EndpointInfo{{Domain: "app.example.com", Upstream: ":8080"}},
+	}
+}
+
+func TestRetryLaggingWaitsForBackoff(t *testing.T) {
+	admin := newFakeAdmin(t)
+	admin.failing.Store(true)
+	m := newTestManager(t, discovery.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour, ReapplyInterval: 2 * time.Hour}, admin)
+
+	m.updateRoutes()
+	if requests := admin.requests.Load(); requests != 1 {
+		t.Fatalf("Expected a single request without retries, got %d", requests)
+	}
+	now := time.Now()
+	if next := m.nextRetry(now); next < 30*time.Minute || next > time.Hour {
+		t.Errorf("Expected the retry after the backoff of up to an hour, got %v", next)
+	}
+
+	m.retryLagging(now)
+	if requests := admin.requests.Load(); requests != 1 {
+		t.Errorf("Expected no request before the backoff elapsed, got %d", requests)
+	}
+
+	admin.failing.Store(false)
+	m.retryLagging(now.Add(2 * time.Hour))
+	if !m.instances[0].status(m.generation).InSync {
+		t.Errorf("Expected the instance to be in sync after the retry")
+	}
+}
+
+func TestInstanceUsesReapplyIntervalAfterMaxAttempts(t *testing.T) {
+	admin := newFakeAdmin(t)
+	admin.failing.Store(true)
+	m := newTestManager(t, discovery.RetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond, ReapplyInterval: time.Minute}, admin)
+
+	m.updateRoutes()
+	inst := m.instances[0]
+	if delay := time.Until(inst.retryAt); delay > time.Millisecond {
+		t.Errorf("Expected the first retry after the backoff, got %v", delay)
+	}
+
+	m.retryLagging(inst.retryAt)
+	if delay := time.Until(inst.retryAt); delay < 50*time.Second {
+		t.Errorf("Expected the reapply interval after %d failures, got %v", inst.failures, delay)
+	}
+}
+
+func TestRejectedGenerationIsNotRetried(t *testing.T) {
+	admin := newFakeAdmin(t)
+	admin.rejecting.Store(true)
+	m := newTestManager(t, discovery.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, ReapplyInterval: time.Minute}, admin)
+
+	m.updateRoutes()
+	if requests := admin.requests.Load(); requests != 1 {
+		t.Fatalf("Expected a single request, got %d", requests)
+	}
+	if next := m.nextRetry(time.Now()); next != time.Minute {
+		t.Errorf("Expected no retry before the reapply interval, got %v", next)
+	}
+
+	m.retryLagging(time.Now().Add(time.Hour))
+	if requests := admin.requests.Load(); requests != 1 {
+		t.Errorf("Expected no retry of the rejected generation, got %d requests", requests)
+	}
+	if status := m.instances[0].status(m.generation); status.InSync || status.LastError == "" {
+		t.Errorf("Expected the instance to lag behind with the rejection, got %+v", status)
+	}
+
+	admin.rejecting.Store(false)
+	m.updateRoutes()
+	if !m.instances[0].status(m.generation).InSync {
+		t.Errorf("Expected the next generation to be applied")
+	}
+}
+
+func TestApplyFansOutAndRetriesLaggingInstancesIndependently(t *testing.T) {
+	healthy := newFakeAdmin(t)
+	failing := newFakeAdmin(t)
+	failing.failing.Store(true)
+	m := newTestManager(t, discovery.RetryConfig{MaxAttempts: 1, ReapplyInterval: time.Minute}, healthy, failing)
+
+	m.updateRoutes()
+	if healthy.requests.Load() == 0 || failing.requests.Load() == 0 {
+		t.Fatalf("Expected requests to both instances, got %d and %d", healthy.requests.Load(), failing.requests.Load())
+	}
+
+	// the instances diverged: only the healthy one applied the generation
+	statuses := m.instanceStatus()
+	if !statuses[0].InSync || statuses[1].InSync {
+		t.Fatalf("Expected only the first instance in sync, got %+v", statuses)
+	}
+	if statuses[1].LastError == "" {
+		t.Errorf("Expected the error of the failing instance, got none")
+	}
+
+	healthyRequests := healthy.requests.Load()
+	failing.failing.Store(false)
+	m.retryLagging(time.Now().Add(2 * time.Minute))
+	if requests := healthy.requests.Load(); requests != healthyRequests {
+		t.Errorf("Expected no requests to the instance in sync, got %d more", requests-healthyRequests)
+	}
+	for _, status := range m.instanceStatus() {
+		if !status.InSync || status.AppliedGeneration != m.generation {
+			t.Errorf("Expected all instances in sync after the retry, got %+v", status)
+		}
+	}
+}
+
+// storeServers stores the servers as config of the fake admin api
+func (a *fakeAdmin) storeServers(servers map[string]caddy.Server) {
+	config := &caddy.Config{}
+	config.Apps.HTTP.Servers = servers
+	a.config.Store(config)
+}
+
+func TestInstanceCheck(t *testing.T) {
+	tests := map[string]struct {
+		prepare               func(admin *fakeAdmin, servers map[string]caddy.Server)
+		expectedInSync        bool
+		expectedConfigCreated bool
+	}{
+		"unchanged servers": {
+			prepare:               func(admin *fakeAdmin, servers map[string]caddy.Server) { admin.storeServers(servers) },
+			expectedInSync:        true,
+			expectedConfigCreated: true,
+		},
+		"drifted server": {
+			prepare: func(admin *fakeAdmin, servers map[string]caddy.Server) {
+				admin.storeServers(map[string]caddy.Server{discovery.DefaultServerName: {Listen: []string{":443"}}})
+			},
+			expectedInSync:        false,
+			expectedConfigCreated: true,
+		},
+		"missing server": {
+			prepare: func(admin *fakeAdmin, servers map[string]caddy.Server) {
+				admin.storeServers(map[string]caddy.Server{"other": {}})
+			},
+			expectedInSync:        false,
+			expectedConfigCreated: false,
+		},
+		"lost config": {
+			prepare:               func(admin *fakeAdmin, servers map[string]caddy.Server) {},
+			expectedInSync:        false,
+			expectedConfigCreated: false,
+		},
+		// an unreachable caddy may still serve its config, so it is only retried instead of being reloaded
+		"read error": {
+			prepare:               func(admin *fakeAdmin, servers map[string]caddy.Server) { admin.failing.Store(true) },
+			expectedInSync:        false,
+			expectedConfigCreated: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			admin := newFakeAdmin(t)
+			m := newTestManager(t, discovery.RetryConfig{}, admin)
+			m.updateRoutes()
+			test.prepare(admin, m.servers)
+
+			inst := m.instances[0]
+			fingerprint, err := caddy.Fingerprint(m.servers[discovery.DefaultServerName])
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			inst.check(map[string]string{discovery.DefaultServerName: fingerprint})
+
+			if inSync := inst.status(m.generation).InSync; inSync != test.expectedInSync {
+				t.Errorf("Expected in sync %t, got %t", test.expectedInSync, inSync)
+			}
+			if inst.configCreated != test.expectedConfigCreated {
+				t.Errorf("Expected config created %t, got %t", test.expectedConfigCreated, inst.configCreated)
+			}
+			if !inst.retryDue(time.Now()) {
+				t.Errorf("Expected the instance to be due for the next apply")
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package manager
+
+import (
+	"sort"
+	"strings"
+)
+
+// maintenanceState holds the maintenance switches set via the api, in addition to the configured ones
+type maintenanceState struct {
+	All     bool
+	Domains map[string]bool
+}
+
+// MaintenanceStatus describes the maintenance switches set via the api
+type MaintenanceStatus struct {
+	All     bool     `json:"all"`
+	Domains []string `json:"domains"`
+}
+
+// inMaintenance reports whether a route of the domain is in maintenance via the api
+func (s maintenanceState) inMaintenance(domain string) bool {
+	return s.All || s.Domains[strings.ToLower(domain)]
+}
+
+// set switches maintenance of the domain on or off, all domains if domain is empty
+func (s *maintenanceState) set(domain string, enabled bool) {
+	if domain == "" {
+		s.All = enabled
+		return
+	}
+	if !enabled {
+		delete(s.Domains, strings.ToLower(domain))
+		return
+	}
+	if s.Domains == nil {
+		s.Domains = make(map[string]bool)
+	}
+	s.Domains[strings.ToLower(domain)] = true
+}
+
+func (s maintenanceState) status() MaintenanceStatus {
+	domains := make([]string, 0, len(s.Domains))
+	for domain := range s.Domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return MaintenanceStatus{All: s.All, Domains: domains}
+}
@@ -1,173 +1,364 @@
 package manager
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jaku01/caddyservicediscovery/internal/caddy"
 	"github.com/jaku01/caddyservicediscovery/internal/discovery"
 	"github.com/jaku01/caddyservicediscovery/internal/provider"
 )
 
-func StartServiceDiscovery(caddyConnector *caddy.Connector, providerConnector provider.ServiceDiscoveryProvider) error {
+const (
+	defaultReapplyInterval  = 15 * time.Second
+	defaultWatchdogInterval = 30 * time.Second
+)
+
+// Manager keeps the discovered endpoints and applies the routes rendered from them to all Caddy instances.
+// Each change of the desired routes increases the generation, instances that did not apply the current
+// generation yet are retried independently until they are reachable again.
+type Manager struct {
+	// config holds the settings shared by all Caddy instances
+	config            *discovery.CaddyConfig
+	instances         []*instance
+	providerConnector provider.ServiceDiscoveryProvider
+
+	endpoints []provider.EndpointInfo
+	// servers holds the desired state of every managed caddy server
+	servers    map[string]caddy.Server
+	generation uint64
+
+	// maintenance holds the maintenance switches set via the api
+	maintenance maintenanceState
+	// commands are run by the event loop, so the api can change the state without locking
+	commands chan func()
+
+	// hosts holds the routed hosts of the desired state for the api, guarded by hostsMu
+	hostsMu sync.RWMutex
+	hosts   map[string]bool
+}
+
+func StartServiceDiscovery(caddyConnectors []*caddy.Connector, providerConnector provider.ServiceDiscoveryProvider) error {
+	if len(caddyConnectors) == 0 {
+		return fmt.Errorf("no caddy instances configured")
+	}
+
 	slog.Info("Starting manager for service discovery")
-	slog.Info("Using caddy admin api", "url", caddyConnector.Config.CaddyAdminUrl)
+	for _, caddyConnector := range caddyConnectors {
+		slog.Info("Using caddy admin api", "url", caddyConnector.Config.CaddyAdminUrl)
+	}
 
-	err := caddyConnector.CreateCaddyConfig()
-	if err != nil {
-		return err
+	m := &Manager{
+		config:            caddyConnectors[0].Config,
+		instances:         newInstances(caddyConnectors, caddyConnectors[0].Config.Retry),
+		providerConnector: providerConnector,
+		commands:          make(chan func()),
 	}
 
-	routes, err := configureInitialRoutes(providerConnector, caddyConnector)
+	err := m.configureInitialRoutes()
 	if err != nil {
 		return err
 	}
 
-	err = handleLifecycleEvents(providerConnector, routes, caddyConnector)
-	if err != nil {
-		return err
+	if m.config.Api.Listen != "" {
+		go m.serveApi(m.config.Api.Listen)
 	}
+
+	m.handleLifecycleEvents()
 	return nil
 }
 
-func handleLifecycleEvents(providerConnector provider.ServiceDiscoveryProvider, routes []caddy.Route, caddyConnector *caddy.Connector) error {
-	for lifecycleEvent := range providerConnector.GetEventChannel() {
-		slog.Info("Received lifecycle event", "content", lifecycleEvent)
-		err := updateRoutes(lifecycleEvent, &routes)
-		if err != nil {
-			return err
+func (m *Manager) handleLifecycleEvents() {
+	// retries of lagging instances are scheduled here, so a backoff never blocks the loop
+	retryTimer := time.NewTimer(m.nextRetry(time.Now()))
+	defer retryTimer.Stop()
+
+	watchdogInterval := m.config.Watchdog.Interval
+	if watchdogInterval <= 0 {
+		watchdogInterval = defaultWatchdogInterval
+	}
+	watchdogTicker := time.NewTicker(watchdogInterval)
+	defer watchdogTicker.Stop()
+
+	events := m.providerConnector.GetEventChannel()
+	for {
+		select {
+		case lifecycleEvent, ok := <-events:
+			if !ok {
+				return
+			}
+			slog.Info("Received lifecycle event", "content", lifecycleEvent)
+			err := updateEndpoints(lifecycleEvent, &m.endpoints)
+			if err != nil {
+				slog.Error("Failed to update routes", "error", err)
+				continue
+			}
+
+			m.updateRoutes()
+		case <-retryTimer.C:
+			m.retryLagging(time.Now())
+		case <-watchdogTicker.C:
+			m.runWatchdog()
+		case command := <-m.commands:
+			command()
 		}
+		retryTimer.Reset(m.nextRetry(time.Now()))
+	}
+}
 
-		routes = ensureFallbackRoute(routes)
-		err = caddyConnector.SetRoutes(routes)
+// nextRetry returns the time until the next retry of a lagging instance is due,
+// the reapply interval if no instance is lagging
+func (m *Manager) nextRetry(now time.Time) time.Duration {
+	next := m.config.Retry.ReapplyInterval
+	if next <= 0 {
+		next = defaultReapplyInterval
+	}
+	for _, inst := range m.retryingInstances() {
+		next = min(next, max(inst.retryAt.Sub(now), 0))
+	}
+	return next
+}
+
+// retryLagging applies the desired state to the lagging instances whose retry is due
+func (m *Manager) retryLagging(now time.Time) {
+	var due []*instance
+	for _, inst := range m.retryingInstances() {
+		if inst.retryDue(now) {
+			due = append(due, inst)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	slog.Info("Retrying to apply queued routes to lagging caddy instances", "instances", len(due))
+	m.applyTo(due)
+}
+
+// runWatchdog checks all up-to-date instances for a lost or drifted config and re-applies the desired state
+func (m *Manager) runWatchdog() {
+	fingerprints := make(map[string]string, len(m.servers))
+	for serverName, server := range m.servers {
+		fingerprint, err := caddy.Fingerprint(server)
 		if err != nil {
-			return err
+			slog.Error("Watchdog could not fingerprint desired server", "server", serverName, "error", err)
+			return
 		}
+		fingerprints[serverName] = fingerprint
 	}
-	return nil
+
+	var inSync []*instance
+	for _, inst := range m.instances {
+		if inst.appliedGeneration == m.generation {
+			inSync = append(inSync, inst)
+		}
+	}
+	checkInstances(inSync, fingerprints)
+	m.retryLagging(time.Now())
 }
 
-func configureInitialRoutes(providerConnector provider.ServiceDiscoveryProvider, caddyConnector *caddy.Connector) ([]caddy.Route, error) {
-	routes, err := providerConnector.GetRoutes()
+func (m *Manager) configureInitialRoutes() error {
+	endpoints, err := m.providerConnector.GetEndpoints()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	slog.Info("Initial server map retrieved, updating caddy configuration")
 
-	for _, manualRoute := range caddyConnector.Config.ManualRoutes {
-		if !hasManualRoute(routes, manualRoute) {
-			reverseProxyRoute := caddy.NewExternalReverseProxyRoute(manualRoute.Domain, manualRoute.Upstream, manualRoute.TLS)
-			routes = append(routes, reverseProxyRoute)
-			slog.Info("Added manual route", "route", manualRoute)
+	m.endpoints = endpoints
+	m.updateRoutes()
+	for _, inst := range m.instances {
+		if inst.appliedGeneration == m.generation {
+			_ = inst.connector.PrintCurrentConfig()
 		}
 	}
+	return nil
+}
+
+// updateRoutes renders the desired servers from the current endpoints as a new generation
+// and applies it to all instances
+func (m *Manager) updateRoutes() {
+	m.servers = renderServers(m.config, m.endpoints, m.maintenance)
+	m.generation++
+	m.setHosts(serverHosts(m.servers))
+	m.apply()
+}
 
-	if !fallbackRouteExists(routes) {
-		routes = append(routes, caddy.New404FallbackRoute())
+func (m *Manager) setHosts(hosts map[string]bool) {
+	m.hostsMu.Lock()
+	defer m.hostsMu.Unlock()
+	m.hosts = hosts
+}
+
+// hasHost reports whether the host is routed by the desired state, either by its name or by a wildcard
+// host like *.example.com, which matches a single label as in Caddy's host matcher
+func (m *Manager) hasHost(host string) bool {
+	m.hostsMu.RLock()
+	defer m.hostsMu.RUnlock()
+	if m.hosts[host] {
+		return true
 	}
-	err = caddyConnector.SetRoutes(routes)
-	if err != nil {
-		return nil, err
+	_, parent, ok := strings.Cut(host, ".")
+	return ok && parent != "" && m.hosts["*."+parent]
+}
+
+// run runs the command on the event loop and waits until it is done. If the context expires before the
+// event loop started the command, the command is skipped and the error of the context is returned. Commands
+// that already started are awaited, so callers never report a failure for a command that ran.
+func (m *Manager) run(ctx context.Context, command func()) error {
+	// whoever takes the start token first either runs the command or skips it
+	start := make(chan struct{}, 1)
+	start <- struct{}{}
+	done := make(chan struct{})
+	select {
+	case m.commands <- func() {
+		defer close(done)
+		select {
+		case <-start:
+			command()
+		default:
+		}
+	}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
 	}
-	_ = caddyConnector.PrintCurrentConfig()
-	return routes, nil
+	select {
+	case <-start:
+		return ctx.Err()
+	default:
+		<-done
+		return nil
+	}
+}
+
+// routedServerHosts returns the hosts routed by every server of the desired state, it has to run on the event loop
+func (m *Manager) routedServerHosts() map[string][]string {
+	hosts := make(map[string][]string, len(m.servers))
+	for serverName, server := range m.servers {
+		hosts[serverName] = server.Hosts()
+	}
+	return hosts
+}
+
+// apply writes the desired routes to every instance that did not apply the current generation yet.
+// Instances that fail keep lagging behind and are retried later.
+func (m *Manager) apply() {
+	m.applyTo(m.laggingInstances())
 }
 
-func updateRoutes(lifecycleEvent provider.LifecycleEvent, routes *[]caddy.Route) error {
+func (m *Manager) applyTo(lagging []*instance) {
+	if len(lagging) == 0 {
+		return
+	}
+
+	applied := applyToInstances(lagging, m.servers, m.generation)
+	if applied < len(lagging) && len(m.instances) > 1 {
+		m.reportDivergence()
+	}
+}
+
+func (m *Manager) laggingInstances() []*instance {
+	var lagging []*instance
+	for _, inst := range m.instances {
+		if inst.appliedGeneration != m.generation {
+			lagging = append(lagging, inst)
+		}
+	}
+	return lagging
+}
+
+// retryingInstances returns the lagging instances that did not reject the current generation
+func (m *Manager) retryingInstances() []*instance {
+	var retrying []*instance
+	for _, inst := range m.instances {
+		if inst.retrying(m.generation) {
+			retrying = append(retrying, inst)
+		}
+	}
+	return retrying
+}
+
+// reportDivergence logs which instances serve an outdated state while others are up-to-date
+func (m *Manager) reportDivergence() {
+	var inSync, diverged []string
+	for _, status := range m.instanceStatus() {
+		if status.InSync {
+			inSync = append(inSync, status.AdminUrl)
+		} else {
+			diverged = append(diverged, status.AdminUrl)
+		}
+	}
+	slog.Warn("Caddy instances diverged, retrying lagging instances",
+		"generation", m.generation, "inSync", inSync, "lagging", diverged)
+}
+
+// instanceStatus returns the synchronisation state of every Caddy instance, it has to run on the event loop
+func (m *Manager) instanceStatus() []InstanceStatus {
+	statuses := make([]InstanceStatus, 0, len(m.instances))
+	for _, inst := range m.instances {
+		statuses = append(statuses, inst.status(m.generation))
+	}
+	return statuses
+}
+
+func updateEndpoints(lifecycleEvent provider.LifecycleEvent, endpoints *[]provider.EndpointInfo) error {
 	switch lifecycleEvent.LifeCycleEventType {
 	case provider.StartEvent:
 		slog.Info("Adding route", "detail", lifecycleEvent.ContainerInfo)
 		// Deduplicate
-		for _, r := range *routes {
-			if isSameRoute(r, lifecycleEvent.ContainerInfo) {
+		for _, e := range *endpoints {
+			if isSameEndpoint(e, lifecycleEvent.ContainerInfo) {
 				return nil
 			}
 		}
-		*routes = append(*routes, caddy.NewReverseProxyRoute(
-			lifecycleEvent.ContainerInfo.Domain,
-			lifecycleEvent.ContainerInfo.Upstream,
-		))
+		*endpoints = append(*endpoints, lifecycleEvent.ContainerInfo)
 		return nil
 
 	case provider.DieEvent:
 		slog.Info("Removing route", "detail", lifecycleEvent.ContainerInfo)
-		newRoutes := make([]caddy.Route, 0, len(*routes))
+		newEndpoints := make([]provider.EndpointInfo, 0, len(*endpoints))
 		removed := false
-		for _, r := range *routes {
-			if isSameRoute(r, lifecycleEvent.ContainerInfo) {
+		for _, e := range *endpoints {
+			if isSameEndpoint(e, lifecycleEvent.ContainerInfo) {
 				removed = true
 				continue
 			}
-			newRoutes = append(newRoutes, r)
+			newEndpoints = append(newEndpoints, e)
 		}
 		if !removed {
 			return fmt.Errorf("route not found for %+v", lifecycleEvent)
 		}
-		*routes = newRoutes
+		*endpoints = newEndpoints
 		return nil
-	}
 
-	return fmt.Errorf("unknown lifecycle event")
-}
-
-func isSameRoute(r caddy.Route, info provider.EndpointInfo) bool {
-	// Host
-	if len(r.Match) == 0 || len(r.Match[0].Host) == 0 {
-		return false
-	}
-	if r.Match[0].Host[0] != info.Domain {
-		return false
-	}
-
-	// Upstream
-	hs := r.Handle[0]
-	rp := hs.Routes[0].Handle[0]
-	if len(rp.Upstreams) == 0 {
-		return false
-	}
-
-	return rp.Upstreams[0].Dial == info.Upstream
-}
-
-func hasManualRoute(routes []caddy.Route, route discovery.ManualRoute) bool {
-	for _, r := range routes {
-		if r.Match[0].Host[0] == route.Domain {
-			return true
+	case provider.UpdateEvent:
+		slog.Info("Updating route", "detail", lifecycleEvent.ContainerInfo)
+		for i, e := range *endpoints {
+			if isSameEndpoint(e, lifecycleEvent.ContainerInfo) {
+				(*endpoints)[i] = lifecycleEvent.ContainerInfo
+				return nil
+			}
 		}
+		*endpoints = append(*endpoints, lifecycleEvent.ContainerInfo)
+		return nil
 	}
-	return false
-}
 
-func fallbackRouteExists(routes []caddy.Route) bool {
-	for _, r := range routes {
-		if len(r.Handle) == 0 {
-			continue
-		}
-		h := r.Handle[0]
-		if h.Handler == "static_response" && h.StatusCode == 404 {
-			return true
-		}
-	}
-	return false
+	return fmt.Errorf("unknown lifecycle event")
 }
 
-func ensureFallbackRoute(routes []caddy.Route) []caddy.Route {
-	var fallback *caddy.Route
-	filtered := make([]caddy.Route, 0, len(routes))
-
-	// Remove the fallback route
-	for _, r := range routes {
-		if len(r.Handle) > 0 && r.Handle[0].Handler == "static_response" && r.Handle[0].StatusCode == 404 {
-			fallback = &r
-		} else {
-			filtered = append(filtered, r)
-		}
+// isSameEndpoint matches endpoints by their id, endpoints of providers without ids by domain and upstream
+func isSameEndpoint(e provider.EndpointInfo, info provider.EndpointInfo) bool {
+	if e.Id != "" && info.Id != "" {
+		return e.Id == info.Id
 	}
-
-	// Add the fallback route to the end of the handlers
-	if fallback != nil {
-		filtered = append(filtered, *fallback)
-	}
-
-	return filtered
+	return e.Domain == info.Domain && e.Upstream == info.Upstream
 }
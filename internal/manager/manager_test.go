@@ -0,0 +1,113 @@
+package manager
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+	"github.com/jaku01/caddyservicediscovery/internal/provider"
+)
+
+func TestUpdateEndpoints(t *testing.T) {
+	shop := provider.EndpointInfo{Id: "default/shop", Domain: "shop.example.com", Upstream: "shop.default.svc.cluster.local:80"}
+	blog := provider.EndpointInfo{Id: "default/blog", Domain: "blog.example.com", Upstream: "blog.default.svc.cluster.local:80"}
+
+	tests := map[string]struct {
+		event    provider.LifecycleEvent
+		expected []provider.EndpointInfo
+	}{
+		"changed options": {
+			event: provider.LifecycleEvent{
+				ContainerInfo: provider.EndpointInfo{Id: "default/shop", Domain: "shop.example.com",
+					Upstream: "shop.default.svc.cluster.local:80", Options: discovery.RouteOptions{Maintenance: true}},
+				LifeCycleEventType: provider.UpdateEvent,
+			},
+			expected: []provider.EndpointInfo{
+				{Id: "default/shop", Domain: "shop.example.com",
+					Upstream: "shop.default.svc.cluster.local:80", Options: discovery.RouteOptions{Maintenance: true}},
+				blog,
+			},
+		},
+		// the endpoint is found by its id, so no stale route of the previous domain and port is left behind
+		"changed domain and port": {
+			event: provider.LifecycleEvent{
+				ContainerInfo:      provider.EndpointInfo{Id: "default/shop", Domain: "store.example.com", Upstream: "shop.default.svc.cluster.local:8080"},
+				LifeCycleEventType: provider.UpdateEvent,
+			},
+			expected: []provider.EndpointInfo{
+				{Id: "default/shop", Domain: "store.example.com", Upstream: "shop.default.svc.cluster.local:8080"},
+				blog,
+			},
+		},
+		"unknown endpoint": {
+			event: provider.LifecycleEvent{
+				ContainerInfo:      provider.EndpointInfo{Id: "default/docs", Domain: "docs.example.com", Upstream: "docs.default.svc.cluster.local:80"},
+				LifeCycleEventType: provider.UpdateEvent,
+			},
+			expected: []provider.EndpointInfo{
+				shop,
+				blog,
+				{Id: "default/docs", Domain: "docs.example.com", Upstream: "docs.default.svc.cluster.local:80"},
+			},
+		},
+		// services whose domain was removed are only known by their id
+		"removed domain": {
+			event: provider.LifecycleEvent{
+				ContainerInfo:      provider.EndpointInfo{Id: "default/shop"},
+				LifeCycleEventType: provider.DieEvent,
+			},
+			expected: []provider.EndpointInfo{blog},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			endpoints := []provider.EndpointInfo{shop, blog}
+			if err := updateEndpoints(test.event, &endpoints); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(endpoints, test.expected) {
+				t.Errorf("Expected %+v, got %+v", test.expected, endpoints)
+			}
+		})
+	}
+}
+
+func TestUpdateEndpointsMatchesEndpointsWithoutId(t *testing.T) {
+	endpoints := []provider.EndpointInfo{{Domain: "shop.example.com", Upstream: ":8080"}}
+
+	err := updateEndpoints(provider.LifecycleEvent{
+		ContainerInfo:      provider.EndpointInfo{Domain: "shop.example.com", Upstream: ":8080"},
+		LifeCycleEventType: provider.DieEvent,
+	}, &endpoints)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(endpoints) != 0 {
+		t.Errorf("Expected no endpoints, got %+v", endpoints)
+	}
+}
+
+func TestRunWaitsForCommandsThatAlreadyStarted(t *testing.T) {
+	m := &Manager{commands: make(chan func())}
+	go func() {
+		(<-m.commands)()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// the command outlives the context, its result must not be reported as timeout
+	ran := false
+	err := m.run(ctx, func() {
+		<-ctx.Done()
+		ran = true
+	})
+	if err != nil {
+		t.Errorf("Expected no error for a started command, got %v", err)
+	}
+	if !ran {
+		t.Errorf("Expected the command to finish before run returns")
+	}
+}
@@ -0,0 +1,407 @@
+package manager
+
+import (
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jaku01/caddyservicediscovery/internal/caddy"
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+	"github.com/jaku01/caddyservicediscovery/internal/provider"
+)
+
+// renderServers builds every managed server from the discovered endpoints and the manual routes.
+// Discovered endpoints of the same domain are balanced in one route, they take precedence over manual routes
+// for the same domain and every server ends with the fallback route. Proxy and static routes in maintenance,
+// configured or set via the api, serve the maintenance page.
+func renderServers(config *discovery.CaddyConfig, endpoints []provider.EndpointInfo, maintenance maintenanceState) map[string]caddy.Server {
+	routes := make(map[string][]caddy.Route)
+	routeCertificates := make(map[string]map[string]string)
+	for _, server := range config.ManagedServers() {
+		routes[server.Name] = []caddy.Route{}
+		routeCertificates[server.Name] = make(map[string]string)
+	}
+
+	certificateTags := make(map[string]bool, len(config.TLSConfig.Certificates))
+	for _, certificate := range config.TLSConfig.Certificates {
+		certificateTags[certificate.SelectionTag()] = true
+	}
+	selectCertificate := func(serverName string, domain string, options discovery.RouteOptions) {
+		if options.Certificate == "" {
+			return
+		}
+		if !certificateTags[options.Certificate] {
+			slog.Error("Ignoring unknown certificate for route", "domain", domain, "certificate", options.Certificate)
+			return
+		}
+		routeCertificates[serverName][domain] = options.Certificate
+	}
+
+	groups := groupEndpoints(config, routes, endpoints)
+	redirectScheme := newRedirectScheme(config, routes, groups)
+
+	domains := make(map[string]bool)
+	var aliases []aliasRedirect
+	for _, group := range groups {
+		domains[group.serverName+"/"+group.domain] = true
+		options, ok := group.sharedOptions()
+		if !ok {
+			continue
+		}
+		options.Maintenance = options.Maintenance || config.Maintenance.Enabled || maintenance.inMaintenance(group.domain)
+		route, ok := newEndpointsRoute(config, group.domain, group.endpoints, options)
+		if !ok {
+			continue
+		}
+		routes[group.serverName] = append(routes[group.serverName], route)
+		selectCertificate(group.serverName, group.domain, options)
+		aliases = append(aliases, aliasRedirect{group.serverName, group.domain, options})
+	}
+
+	for _, manualRoute := range config.ManualRoutes {
+		serverName, ok := routeServer(config, routes, manualRoute.RouteOptions)
+		if !ok {
+			slog.Error("Skipping manual route for unknown server", "domain", manualRoute.Domain, "server", manualRoute.Server)
+			continue
+		}
+
+		if domains[serverName+"/"+manualRoute.Domain] {
+			continue
+		}
+		manualRoute.Maintenance = manualRoute.Maintenance || config.Maintenance.Enabled || maintenance.inMaintenance(manualRoute.Domain)
+		route, ok := newManualRoute(config, manualRoute, redirectScheme(serverName, manualRoute.RedirectTo))
+		if !ok {
+			continue
+		}
+		domains[serverName+"/"+manualRoute.Domain] = true
+		routes[serverName] = append(routes[serverName], route)
+		selectCertificate(serverName, manualRoute.Domain, manualRoute.RouteOptions)
+		aliases = append(aliases, aliasRedirect{serverName, manualRoute.Domain, manualRoute.RouteOptions})
+	}
+
+	// aliases come last, so they never shadow a host routed by a service or a manual route
+	for _, alias := range aliases {
+		var hosts []string
+		for _, host := range alias.options.Aliases {
+			if domains[alias.serverName+"/"+host] {
+				slog.Warn("Skipping alias of an already routed host", "domain", alias.domain, "alias", host)
+				continue
+			}
+			domains[alias.serverName+"/"+host] = true
+			hosts = append(hosts, host)
+		}
+		if len(hosts) == 0 {
+			continue
+		}
+		statusCode, ok := redirectStatus(alias.domain, alias.options.RedirectStatus)
+		if !ok {
+			continue
+		}
+		routes[alias.serverName] = append(routes[alias.serverName], caddy.NewRedirectRoute(hosts, redirectScheme(alias.serverName, alias.domain), alias.domain, statusCode))
+	}
+
+	servers := make(map[string]caddy.Server, len(routes))
+	for _, managedServer := range config.ManagedServers() {
+		server := caddy.Server{
+			Listen: managedServer.Listen,
+			Routes: append(routes[managedServer.Name], caddy.NewFallbackRoute(config.Fallback)),
+			TLSConnectionPolicies: caddy.NewTLSConnectionPolicies(
+				config.TLSConfig.Certificates, routeCertificates[managedServer.Name]),
+			TrustedProxies: caddy.NewTrustedProxies(config.TrustedProxies),
+			Errors:         caddy.NewServerErrors(config.ErrorPages),
+		}
+		if config.TLSConfig.Internal.Wildcard && len(config.TLSConfig.Internal.BaseDomains) > 0 {
+			server.AutomaticHTTPS = &caddy.AutomaticHTTPS{PreferWildcard: true}
+		}
+		servers[managedServer.Name] = server
+	}
+	return servers
+}
+
+// endpointGroup holds the endpoints serving the same domain on a server
+type endpointGroup struct {
+	serverName string
+	domain     string
+	endpoints  []provider.EndpointInfo
+	// oldest is the endpoint that joined the group first, its options apply if the endpoints disagree
+	oldest provider.EndpointInfo
+}
+
+// sharedOptions returns the route options of the group, which are the options of the oldest endpoint. Endpoints may
+// differ in their versions, other differences are logged. Groups whose endpoints differ in their protection are
+// skipped, so no endpoint is exposed with the protection of another.
+func (g *endpointGroup) sharedOptions() (discovery.RouteOptions, bool) {
+	options := g.oldest.Options
+	for _, endpoint := range g.endpoints {
+		other := endpoint.Options
+		other.Version = options.Version
+		if reflect.DeepEqual(options, other) {
+			continue
+		}
+		if !sameProtection(options, other) {
+			slog.Error("Skipping domain whose endpoints have conflicting protection", "domain", g.domain,
+				"upstream", g.oldest.Upstream, "conflictingUpstream", endpoint.Upstream)
+			return discovery.RouteOptions{}, false
+		}
+		slog.Warn("Endpoints of domain have conflicting options, using the options of the oldest endpoint",
+			"domain", g.domain, "upstream", g.oldest.Upstream, "conflictingUpstream", endpoint.Upstream)
+	}
+	return options, true
+}
+
+// sameProtection reports whether the options authenticate and restrict clients and verify the upstream alike
+func sameProtection(options discovery.RouteOptions, other discovery.RouteOptions) bool {
+	return reflect.DeepEqual(options.BasicAuth, other.BasicAuth) &&
+		reflect.DeepEqual(options.ForwardAuth, other.ForwardAuth) &&
+		reflect.DeepEqual(options.Access, other.Access) &&
+		reflect.DeepEqual(options.UpstreamTLS, other.UpstreamTLS)
+}
+
+// groupEndpoints groups the endpoints by server and domain in order of their first endpoint.
+// The endpoints of a group are sorted by upstream, so the rendered config does not depend on the event order.
+func groupEndpoints(config *discovery.CaddyConfig, routes map[string][]caddy.Route, endpoints []provider.EndpointInfo) []*endpointGroup {
+	var groups []*endpointGroup
+	groupsByDomain := make(map[string]*endpointGroup)
+	for _, endpoint := range endpoints {
+		serverName, ok := routeServer(config, routes, endpoint.Options)
+		if !ok {
+			slog.Error("Skipping route for unknown server", "domain", endpoint.Domain, "server", endpoint.Options.Server)
+			continue
+		}
+
+		key := serverName + "/" + endpoint.Domain
+		group, ok := groupsByDomain[key]
+		if !ok {
+			group = &endpointGroup{serverName: serverName, domain: endpoint.Domain, oldest: endpoint}
+			groupsByDomain[key] = group
+			groups = append(groups, group)
+		}
+		group.endpoints = append(group.endpoints, endpoint)
+	}
+
+	for _, group := range groups {
+		sort.SliceStable(group.endpoints, func(i, j int) bool {
+			return group.endpoints[i].Upstream < group.endpoints[j].Upstream
+		})
+	}
+	return groups
+}
+
+// newRedirectScheme returns a function resolving the scheme of redirects to a target, the scheme of the server
+// routing the target host or of the redirect's own server if the target is not routed here
+func newRedirectScheme(config *discovery.CaddyConfig, routes map[string][]caddy.Route, groups []*endpointGroup) func(serverName string, target string) string {
+	serverConfigs := make(map[string]discovery.ServerConfig)
+	for _, server := range config.ManagedServers() {
+		serverConfigs[server.Name] = server
+	}
+	targetServers := make(map[string]string)
+	for _, group := range groups {
+		if _, ok := targetServers[group.domain]; !ok {
+			targetServers[group.domain] = group.serverName
+		}
+	}
+	for _, manualRoute := range config.ManualRoutes {
+		if serverName, ok := routeServer(config, routes, manualRoute.RouteOptions); ok {
+			if _, ok := targetServers[manualRoute.Domain]; !ok {
+				targetServers[manualRoute.Domain] = serverName
+			}
+		}
+	}
+
+	return func(serverName string, target string) string {
+		host, _, _ := strings.Cut(target, "/")
+		if targetServer, ok := targetServers[host]; ok {
+			serverName = targetServer
+		}
+		return serverConfigs[serverName].Scheme()
+	}
+}
+
+// newEndpointsRoute creates the proxy route of a domain served by one or several endpoints
+func newEndpointsRoute(config *discovery.CaddyConfig, domain string, endpoints []provider.EndpointInfo, options discovery.RouteOptions) (caddy.Route, bool) {
+	if len(endpoints) == 1 {
+		return newServiceRoute(config, domain, endpoints[0].Upstream, options)
+	}
+
+	options, ok := resolveRouteOptions(config, domain, options)
+	if !ok {
+		return caddy.Route{}, false
+	}
+	if options.Maintenance {
+		return caddy.NewMaintenanceRoute(domain, config.Maintenance, options), true
+	}
+
+	upstreams := make([]caddy.VersionedUpstream, 0, len(endpoints))
+	weighted := false
+	totalWeight := 0
+	for _, endpoint := range endpoints {
+		version := endpoint.Options.Version
+		if err := version.Validate(); err != nil {
+			slog.Error("Ignoring invalid version of endpoint", "domain", domain, "upstream", endpoint.Upstream, "error", err)
+			version = discovery.VersionConfig{}
+		}
+		weighted = weighted || version.Weight != nil
+		totalWeight += version.EffectiveWeight()
+		upstreams = append(upstreams, caddy.VersionedUpstream{
+			Dial:    endpoint.Upstream,
+			Version: version.Name,
+			Weight:  version.EffectiveWeight(),
+		})
+	}
+	if weighted && totalWeight == 0 {
+		slog.Error("Ignoring weights of a domain whose endpoints all have weight 0", "domain", domain)
+		weighted = false
+	}
+	return caddy.NewVersionedServiceRoute(domain, upstreams, weighted, options), true
+}
+
+// aliasRedirect holds the aliases of a routed domain until all routed domains are known
+type aliasRedirect struct {
+	serverName string
+	domain     string
+	options    discovery.RouteOptions
+}
+
+// newManualRoute creates the route of a manual route depending on its type, redirects to a host use the redirect scheme
+func newManualRoute(config *discovery.CaddyConfig, manualRoute discovery.ManualRoute, redirectScheme string) (caddy.Route, bool) {
+	switch manualRoute.Type {
+	case "", discovery.RouteTypeProxy:
+		options := manualRoute.RouteOptions
+		options.UpstreamTLS.Enabled = options.UpstreamTLS.Enabled || manualRoute.TLS
+		return newServiceRoute(config, manualRoute.Domain, manualRoute.Upstream, options)
+	case discovery.RouteTypeRedirect:
+		if manualRoute.RedirectTo == "" {
+			slog.Error("Skipping redirect route without target", "domain", manualRoute.Domain)
+			return caddy.Route{}, false
+		}
+		statusCode, ok := redirectStatus(manualRoute.Domain, manualRoute.RedirectStatus)
+		if !ok {
+			return caddy.Route{}, false
+		}
+		return caddy.NewRedirectRoute([]string{manualRoute.Domain}, redirectScheme, manualRoute.RedirectTo, statusCode), true
+	case discovery.RouteTypeStatic:
+		if manualRoute.Static.Root == "" {
+			slog.Error("Skipping static route without root", "domain", manualRoute.Domain)
+			return caddy.Route{}, false
+		}
+		options, ok := resolveRouteOptions(config, manualRoute.Domain, manualRoute.RouteOptions)
+		if !ok {
+			return caddy.Route{}, false
+		}
+		if options.Maintenance {
+			return caddy.NewMaintenanceRoute(manualRoute.Domain, config.Maintenance, options), true
+		}
+		return caddy.NewStaticRoute(manualRoute.Domain, manualRoute.Static, options), true
+	default:
+		slog.Error("Skipping manual route of unknown type", "domain", manualRoute.Domain, "type", manualRoute.Type)
+		return caddy.Route{}, false
+	}
+}
+
+// redirectStatus returns the status code of redirects of the domain, 301 if none is configured
+func redirectStatus(domain string, statusCode int) (int, bool) {
+	if statusCode == 0 {
+		return http.StatusMovedPermanently, true
+	}
+	if !caddy.IsRedirectStatus(statusCode) {
+		slog.Error("Skipping redirect with invalid status code", "domain", domain, "status", statusCode)
+		return 0, false
+	}
+	return statusCode, true
+}
+
+// newServiceRoute creates the proxy route of a service with the global options applied
+func newServiceRoute(config *discovery.CaddyConfig, domain string, upstream string, options discovery.RouteOptions) (caddy.Route, bool) {
+	options, ok := resolveRouteOptions(config, domain, options)
+	if !ok {
+		return caddy.Route{}, false
+	}
+	if options.Maintenance {
+		return caddy.NewMaintenanceRoute(domain, config.Maintenance, options), true
+	}
+	return caddy.NewServiceRoute(domain, upstream, options), true
+}
+
+// resolveRouteOptions applies the global options to the options of a route. Routes with invalid access
+// restrictions, basic auth accounts or forward auth service are skipped, so protected services are never
+// exposed without protection.
+func resolveRouteOptions(config *discovery.CaddyConfig, domain string, options discovery.RouteOptions) (discovery.RouteOptions, bool) {
+	options.Headers = config.Headers.Merge(options.Headers)
+	options.ForwardAuth = config.ForwardAuth.Merge(options.ForwardAuth)
+	options.Compression = config.Compression.Merge(options.Compression)
+	options.Headers = withCacheControl(options.Headers, config.CacheControl, options.CacheControl)
+
+	if options.ForwardAuth.IsEnabled() && options.ForwardAuth.Address == "" {
+		slog.Error("Skipping route with forward auth but without auth service address", "domain", domain)
+		return discovery.RouteOptions{}, false
+	}
+	if err := options.Access.Validate(); err != nil {
+		slog.Error("Skipping route with invalid access restrictions", "domain", domain, "error", err)
+		return discovery.RouteOptions{}, false
+	}
+	for _, encoding := range options.Compression.Encodings {
+		if !caddy.IsSupportedEncoding(encoding) {
+			slog.Error("Disabling compression with unsupported encoding", "domain", domain, "encoding", encoding)
+			disabled := false
+			options.Compression.Enabled = &disabled
+			break
+		}
+	}
+	if err := options.Version.Validate(); err != nil {
+		slog.Error("Ignoring invalid version pinning", "domain", domain, "error", err)
+		options.Version = discovery.VersionConfig{}
+	}
+	if err := options.LoadBalancing.Validate(); err != nil {
+		slog.Error("Ignoring invalid load balancing", "domain", domain, "error", err)
+		options.LoadBalancing = discovery.LoadBalancingConfig{}
+	}
+	// behind trusted proxies the direct peer is always a proxy, so all clients would hash to the same upstream
+	if options.LoadBalancing.Policy == discovery.LoadBalancingIPHash && len(config.TrustedProxies) > 0 {
+		options.LoadBalancing.Policy = discovery.LoadBalancingClientIPHash
+	}
+	if options.BasicAuth.IsEnabled() {
+		basicAuth, err := options.BasicAuth.Resolve()
+		if err != nil {
+			slog.Error("Skipping route with invalid basic auth", "domain", domain, "error", err)
+			return discovery.RouteOptions{}, false
+		}
+		options.BasicAuth = basicAuth
+	}
+	return options, true
+}
+
+// withCacheControl sets the Cache-Control response header of the route or the global one,
+// a Cache-Control header set by the header operations wins
+func withCacheControl(headers discovery.HeadersConfig, globalCacheControl string, cacheControl string) discovery.HeadersConfig {
+	if cacheControl == "" {
+		cacheControl = globalCacheControl
+	}
+	if cacheControl == "" {
+		return headers
+	}
+	return discovery.HeadersConfig{
+		Response: discovery.HeaderOpsConfig{Set: map[string]string{"Cache-Control": cacheControl}},
+	}.Merge(headers)
+}
+
+// routeServer resolves the server of a route, routes without a server belong to the default server
+func routeServer(config *discovery.CaddyConfig, routes map[string][]caddy.Route, options discovery.RouteOptions) (string, bool) {
+	if options.Server == "" {
+		return config.DefaultServer(), true
+	}
+	_, ok := routes[options.Server]
+	return options.Server, ok
+}
+
+// serverHosts returns the lower-cased hosts matched by the routes of all servers
+func serverHosts(servers map[string]caddy.Server) map[string]bool {
+	hosts := make(map[string]bool)
+	for _, server := range servers {
+		for _, host := range server.Hosts() {
+			hosts[strings.ToLower(host)] = true
+		}
+	}
+	return hosts
+}
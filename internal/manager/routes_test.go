@@ -0,0 +1,592 @@
+package manager
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+	"github.com/jaku01/caddyservicediscovery/internal/provider"
+)
+
+func TestRenderServersAssignsRoutesToServers(t *testing.T) {
+	config := &discovery.CaddyConfig{
+		Servers: []discovery.ServerConfig{
+			{Name: "public", Listen: []string{":443"}},
+			{Name: "internal", Listen: []string{"10.0.0.1:8443"}},
+		},
+		ManualRoutes: []discovery.ManualRoute{
+			{Domain: "manual.example.com", Upstream: "1.2.3.4:80", RouteOptions: discovery.RouteOptions{Server: "internal"}},
+			{Domain: "app.example.com", Upstream: "1.2.3.4:80"},
+		},
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "app.example.com", Upstream: ":8080"},
+		{Domain: "admin.example.com", Upstream: ":9090", Options: discovery.RouteOptions{Server: "internal"}},
+		{Domain: "unknown.example.com", Upstream: ":9091", Options: discovery.RouteOptions{Server: "missing"}},
+	}
+
+	servers := renderServers(config, endpoints, maintenanceState{})
+
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 servers, got %d", len(servers))
+	}
+	// app.example.com is discovered, so the manual route is skipped
+	if len(servers["public"].Routes) != 2 {
+		t.Fatalf("Expected 2 public routes, got %d", len(servers["public"].Routes))
+	}
+	if servers["public"].Routes[0].Match[0].Host[0] != "app.example.com" {
+		t.Errorf("Expected app.example.com on public server, got %s", servers["public"].Routes[0].Match[0].Host[0])
+	}
+	if len(servers["internal"].Routes) != 3 {
+		t.Fatalf("Expected 3 internal routes, got %d", len(servers["internal"].Routes))
+	}
+	if servers["internal"].Routes[0].Match[0].Host[0] != "admin.example.com" {
+		t.Errorf("Expected admin.example.com on internal server, got %s", servers["internal"].Routes[0].Match[0].Host[0])
+	}
+	if servers["internal"].Routes[1].Match[0].Host[0] != "manual.example.com" {
+		t.Errorf("Expected manual.example.com on internal server, got %s", servers["internal"].Routes[1].Match[0].Host[0])
+	}
+	if servers["internal"].Listen[0] != "10.0.0.1:8443" {
+		t.Errorf("Expected internal server to listen on 10.0.0.1:8443, got %v", servers["internal"].Listen)
+	}
+	for serverName, server := range servers {
+		fallback := server.Routes[len(server.Routes)-1]
+		if fallback.Handle[0].Handler != "static_response" || fallback.Handle[0].StatusCode != 404 {
+			t.Errorf("Expected fallback route at the end of server %s", serverName)
+		}
+	}
+}
+
+func TestRenderServersSelectsRouteCertificates(t *testing.T) {
+	config := &discovery.CaddyConfig{
+		TLSConfig: discovery.TLSConfig{
+			Certificates: []discovery.CertificateConfig{
+				{CertFilePath: "/certs/customer-a.crt", KeyFilePath: "/certs/customer-a.key", Tag: "customer-a"},
+				{CertFilePath: "/certs/customer-b.crt", KeyFilePath: "/certs/customer-b.key", Hosts: []string{"customer-b.com"}},
+			},
+		},
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "customer-a.com", Upstream: ":8080", Options: discovery.RouteOptions{Certificate: "customer-a"}},
+		{Domain: "other.com", Upstream: ":8081", Options: discovery.RouteOptions{Certificate: "unknown"}},
+	}
+
+	policies := renderServers(config, endpoints, maintenanceState{})[discovery.DefaultServerName].TLSConnectionPolicies
+
+	if len(policies) != 3 {
+		t.Fatalf("Expected 3 connection policies, got %d", len(policies))
+	}
+	if policies[0].Match.SNI[0] != "customer-a.com" || policies[0].CertificateSelection.AnyTag[0] != "customer-a" {
+		t.Errorf("Expected certificate customer-a for customer-a.com, got %+v", policies[0])
+	}
+	if policies[1].Match.SNI[0] != "customer-b.com" || policies[1].CertificateSelection.AnyTag[0] != "/certs/customer-b.crt" {
+		t.Errorf("Expected certificate of customer-b for customer-b.com, got %+v", policies[1])
+	}
+	if policies[2].Match != nil || policies[2].CertificateSelection != nil {
+		t.Errorf("Expected catch-all policy, got %+v", policies[2])
+	}
+}
+
+func TestRenderServersConfiguresUpstreamTLS(t *testing.T) {
+	config := &discovery.CaddyConfig{
+		ManualRoutes: []discovery.ManualRoute{
+			{Domain: "legacy.example.com", Upstream: "1.2.3.4:443", TLS: true},
+			{Domain: "mtls.example.com", Upstream: "1.2.3.5:443", RouteOptions: discovery.RouteOptions{
+				UpstreamTLS: discovery.UpstreamTLSConfig{
+					CAFilePath:   "/etc/certs/backend-ca.pem",
+					CertFilePath: "/etc/certs/client.crt",
+					KeyFilePath:  "/etc/certs/client.key",
+				},
+			}},
+		},
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "plain.example.com", Upstream: ":8080"},
+		{Domain: "secure.example.com", Upstream: ":8443", Options: discovery.RouteOptions{
+			UpstreamTLS: discovery.UpstreamTLSConfig{ServerName: "backend.internal"},
+		}},
+	}
+
+	routes := renderServers(config, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	if transport := routes[0].Handle[0].Routes[0].Handle[0].Transport; transport != nil {
+		t.Errorf("Expected no transport for plain.example.com, got %+v", transport)
+	}
+	secure := routes[1].Handle[0].Routes[0].Handle[0].Transport
+	if secure == nil || secure.TLS == nil || secure.TLS.ServerName != "backend.internal" {
+		t.Errorf("Expected TLS with server name backend.internal for secure.example.com, got %+v", secure)
+	}
+	legacy := routes[2].Handle[0].Routes[0].Handle[0].Transport
+	if legacy == nil || legacy.TLS == nil {
+		t.Errorf("Expected TLS for legacy.example.com, got %+v", legacy)
+	}
+	mtls := routes[3].Handle[0].Routes[0].Handle[0].Transport
+	if mtls == nil || mtls.TLS == nil {
+		t.Fatalf("Expected TLS for mtls.example.com, got %+v", mtls)
+	}
+	if len(mtls.TLS.RootCAPEMFiles) != 1 || mtls.TLS.RootCAPEMFiles[0] != "/etc/certs/backend-ca.pem" {
+		t.Errorf("Expected root CA /etc/certs/backend-ca.pem, got %v", mtls.TLS.RootCAPEMFiles)
+	}
+	if mtls.TLS.ClientCertificateFile != "/etc/certs/client.crt" || mtls.TLS.ClientCertificateKeyFile != "/etc/certs/client.key" {
+		t.Errorf("Expected client certificate, got %+v", mtls.TLS)
+	}
+}
+
+func TestRenderServersMergesGlobalHeaders(t *testing.T) {
+	config := &discovery.CaddyConfig{
+		Headers: discovery.HeadersConfig{
+			Response: discovery.HeaderOpsConfig{
+				Set:    map[string]string{"x-frame-options": "DENY", "Strict-Transport-Security": "max-age=31536000"},
+				Delete: []string{"Server"},
+			},
+		},
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "app.example.com", Upstream: ":8080", Options: discovery.RouteOptions{
+			Headers: discovery.HeadersConfig{
+				Response: discovery.HeaderOpsConfig{Set: map[string]string{"X-Frame-Options": "SAMEORIGIN"}},
+			},
+		}},
+	}
+
+	routes := renderServers(config, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	headers := routes[0].Handle[0].Routes[0].Handle[0]
+	if headers.Handler != "headers" || headers.Response == nil {
+		t.Fatalf("Expected response headers handler, got %+v", headers)
+	}
+	if value := headers.Response.Set["X-Frame-Options"]; len(value) != 1 || value[0] != "SAMEORIGIN" {
+		t.Errorf("Expected route value SAMEORIGIN for X-Frame-Options, got %v", value)
+	}
+	if value := headers.Response.Set["Strict-Transport-Security"]; len(value) != 1 {
+		t.Errorf("Expected global Strict-Transport-Security header, got %v", headers.Response.Set)
+	}
+	if len(headers.Response.Delete) != 1 || headers.Response.Delete[0] != "Server" {
+		t.Errorf("Expected global delete of Server, got %v", headers.Response.Delete)
+	}
+}
+
+func TestRenderServersResolvesBasicAuth(t *testing.T) {
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(htpasswd, []byte("# admins\nalice:$2y$10$alice\n"), 0o600); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	config := &discovery.CaddyConfig{
+		ManualRoutes: []discovery.ManualRoute{
+			{Domain: "grafana.example.com", Upstream: "10.0.0.60:3000", RouteOptions: discovery.RouteOptions{
+				BasicAuth: discovery.BasicAuthConfig{File: htpasswd},
+			}},
+		},
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "admin.example.com", Upstream: ":9000", Options: discovery.RouteOptions{
+			BasicAuth: discovery.BasicAuthConfig{Users: map[string]string{"bob": "plaintext"}},
+		}},
+		{Domain: "missing.example.com", Upstream: ":9001", Options: discovery.RouteOptions{
+			BasicAuth: discovery.BasicAuthConfig{File: filepath.Join(t.TempDir(), "missing")},
+		}},
+	}
+
+	routes := renderServers(config, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	// routes with invalid credentials are skipped instead of being exposed without authentication
+	if len(routes) != 2 {
+		t.Fatalf("Expected the grafana route and the fallback route, got %d routes", len(routes))
+	}
+	if routes[0].Match[0].Host[0] != "grafana.example.com" {
+		t.Fatalf("Expected grafana.example.com, got %s", routes[0].Match[0].Host[0])
+	}
+	authentication := routes[0].Handle[0].Routes[0].Handle[0]
+	if authentication.Handler != "authentication" {
+		t.Fatalf("Expected authentication handler, got %s", authentication.Handler)
+	}
+	accounts := authentication.Providers.HTTPBasic.Accounts
+	if len(accounts) != 1 || accounts[0].Username != "alice" || accounts[0].Password != "$2y$10$alice" {
+		t.Errorf("Expected account alice from the htpasswd file, got %+v", accounts)
+	}
+}
+
+func TestRenderServersAppliesGlobalForwardAuth(t *testing.T) {
+	enabled := true
+	disabled := false
+	config := &discovery.CaddyConfig{
+		ForwardAuth: discovery.ForwardAuthConfig{
+			Enabled: &enabled,
+			Address: "oauth2-proxy:4180",
+			Uri:     "/oauth2/auth",
+		},
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "app.example.com", Upstream: ":8080"},
+		{Domain: "auth.example.com", Upstream: ":4180", Options: discovery.RouteOptions{
+			ForwardAuth: discovery.ForwardAuthConfig{Enabled: &disabled},
+		}},
+	}
+
+	routes := renderServers(config, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	app := routes[0].Handle[0].Routes[0].Handle
+	if len(app) != 2 || app[0].Upstreams[0].Dial != "oauth2-proxy:4180" {
+		t.Errorf("Expected forward auth for app.example.com, got %+v", app)
+	}
+	auth := routes[1].Handle[0].Routes[0].Handle
+	if len(auth) != 1 || auth[0].Upstreams[0].Dial != ":4180" {
+		t.Errorf("Expected no forward auth for opted out auth.example.com, got %+v", auth)
+	}
+}
+
+func TestRenderServersSkipsForwardAuthWithoutAddress(t *testing.T) {
+	enabled := true
+	endpoints := []provider.EndpointInfo{
+		{Domain: "app.example.com", Upstream: ":8080", Options: discovery.RouteOptions{
+			ForwardAuth: discovery.ForwardAuthConfig{Enabled: &enabled},
+		}},
+	}
+
+	routes := renderServers(&discovery.CaddyConfig{}, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	if len(routes) != 1 {
+		t.Errorf("Expected only the fallback route, got %d routes", len(routes))
+	}
+}
+
+func TestRenderServersRestrictsClientAddresses(t *testing.T) {
+	config := &discovery.CaddyConfig{
+		TrustedProxies: []string{"192.168.0.0/24"},
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "admin.example.com", Upstream: ":9000", Options: discovery.RouteOptions{
+			Access: discovery.AccessConfig{Allow: []string{"10.8.0.0/16"}},
+		}},
+		{Domain: "invalid.example.com", Upstream: ":9001", Options: discovery.RouteOptions{
+			Access: discovery.AccessConfig{Allow: []string{"10.8.0.0/33"}},
+		}},
+	}
+
+	server := renderServers(config, endpoints, maintenanceState{})[discovery.DefaultServerName]
+
+	if server.TrustedProxies == nil || server.TrustedProxies.Ranges[0] != "192.168.0.0/24" {
+		t.Errorf("Expected trusted proxies 192.168.0.0/24, got %+v", server.TrustedProxies)
+	}
+	// the route with an invalid range is skipped instead of being exposed without restriction
+	if len(server.Routes) != 2 {
+		t.Fatalf("Expected the admin route and the fallback route, got %d routes", len(server.Routes))
+	}
+	denied := server.Routes[0].Handle[0].Routes[0]
+	if denied.Handle[0].StatusCode != 403 {
+		t.Errorf("Expected 403 for clients outside 10.8.0.0/16, got %+v", denied.Handle[0])
+	}
+}
+
+func TestRenderServersRedirectsAliasesAndRedirectRoutes(t *testing.T) {
+	config := &discovery.CaddyConfig{
+		ManualRoutes: []discovery.ManualRoute{
+			{Domain: "example.com", Type: discovery.RouteTypeRedirect, RedirectTo: "www.example.com"},
+			{Domain: "legacy.example.com", Type: discovery.RouteTypeRedirect, RedirectTo: "www.example.com",
+				RouteOptions: discovery.RouteOptions{RedirectStatus: 200}},
+		},
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "www.example.com", Upstream: ":8080", Options: discovery.RouteOptions{
+			Aliases:        []string{"old.example.com", "example.com"},
+			RedirectStatus: 308,
+		}},
+	}
+
+	routes := renderServers(config, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	// service, manual redirect, alias redirect and fallback, the route with an invalid status is skipped
+	if len(routes) != 4 {
+		t.Fatalf("Expected 4 routes, got %d", len(routes))
+	}
+	if routes[1].Match[0].Host[0] != "example.com" || routes[1].Handle[1].StatusCode != 301 {
+		t.Errorf("Expected 301 redirect for example.com, got %+v", routes[1])
+	}
+	// example.com is already redirected by the manual route, so only old.example.com is an alias
+	if len(routes[2].Match[0].Host) != 1 || routes[2].Match[0].Host[0] != "old.example.com" {
+		t.Errorf("Expected alias old.example.com, got %v", routes[2].Match[0].Host)
+	}
+	if routes[2].Handle[1].StatusCode != 308 {
+		t.Errorf("Expected 308 for the alias, got %d", routes[2].Handle[1].StatusCode)
+	}
+	if location := routes[2].Handle[0].Response.Set["Location"][0]; location != "https://www.example.com{http.request.uri}" {
+		t.Errorf("Expected redirect to www.example.com, got %s", location)
+	}
+}
+
+func TestRenderServersRedirectsWithTheSchemeOfTheTargetServer(t *testing.T) {
+	config := &discovery.CaddyConfig{
+		Servers: []discovery.ServerConfig{
+			{Name: "public", Listen: []string{":443", ":80"}},
+			{Name: "intranet", Listen: []string{":80"}},
+		},
+		ManualRoutes: []discovery.ManualRoute{
+			{Domain: "wiki.example.com", Type: discovery.RouteTypeRedirect, RedirectTo: "intranet.example.com"},
+			{Domain: "old.example.com", Type: discovery.RouteTypeRedirect, RedirectTo: "www.example.com",
+				RouteOptions: discovery.RouteOptions{Server: "intranet"}},
+		},
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "intranet.example.com", Upstream: ":8080", Options: discovery.RouteOptions{
+			Server:  "intranet",
+			Aliases: []string{"intra.example.com"},
+		}},
+	}
+
+	servers := renderServers(config, endpoints, maintenanceState{})
+
+	// the target of the redirect is served by the http-only server
+	if location := servers["public"].Routes[0].Handle[0].Response.Set["Location"][0]; location != "http://intranet.example.com{http.request.uri}" {
+		t.Errorf("Expected redirect to http://intranet.example.com, got %s", location)
+	}
+	intranetRoutes := servers["intranet"].Routes
+	if len(intranetRoutes) != 4 {
+		t.Fatalf("Expected service, manual redirect, alias redirect and fallback, got %d routes", len(intranetRoutes))
+	}
+	// targets that are not routed here use the scheme of the redirect's own server
+	if location := intranetRoutes[1].Handle[0].Response.Set["Location"][0]; location != "http://www.example.com{http.request.uri}" {
+		t.Errorf("Expected redirect to http://www.example.com, got %s", location)
+	}
+	if location := intranetRoutes[2].Handle[0].Response.Set["Location"][0]; location != "http://intranet.example.com{http.request.uri}" {
+		t.Errorf("Expected alias redirect to http://intranet.example.com, got %s", location)
+	}
+}
+
+func TestRenderServersDoesNotReserveDomainsOfSkippedManualRoutes(t *testing.T) {
+	config := &discovery.CaddyConfig{
+		ManualRoutes: []discovery.ManualRoute{
+			{Domain: "legacy.example.com", Type: discovery.RouteTypeRedirect},
+		},
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "www.example.com", Upstream: ":8080", Options: discovery.RouteOptions{
+			Aliases: []string{"legacy.example.com"},
+		}},
+	}
+
+	routes := renderServers(config, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	// the redirect without target is skipped, so the alias still redirects legacy.example.com
+	if len(routes) != 3 {
+		t.Fatalf("Expected service, alias redirect and fallback, got %d routes", len(routes))
+	}
+	if routes[1].Match[0].Host[0] != "legacy.example.com" {
+		t.Errorf("Expected alias legacy.example.com, got %v", routes[1].Match[0].Host)
+	}
+}
+
+func TestRenderServersCreatesStaticRoutes(t *testing.T) {
+	config := &discovery.CaddyConfig{
+		ManualRoutes: []discovery.ManualRoute{
+			{Domain: "app.example.com", Type: discovery.RouteTypeStatic, Static: discovery.StaticConfig{Root: "/srv/app", SPA: true}},
+			{Domain: "broken.example.com", Type: discovery.RouteTypeStatic},
+			{Domain: "unknown.example.com", Type: "php"},
+		},
+	}
+
+	routes := renderServers(config, nil, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	if len(routes) != 2 {
+		t.Fatalf("Expected the static route and the fallback route, got %d routes", len(routes))
+	}
+	if routes[0].Match[0].Host[0] != "app.example.com" {
+		t.Errorf("Expected app.example.com, got %s", routes[0].Match[0].Host[0])
+	}
+	subroutes := routes[0].Handle[0].Routes
+	if fileServer := subroutes[len(subroutes)-1].Handle[0]; fileServer.Handler != "file_server" || fileServer.Root != "/srv/app" {
+		t.Errorf("Expected file server for /srv/app, got %+v", fileServer)
+	}
+}
+
+func TestRenderServersAppliesCompressionAndCacheControl(t *testing.T) {
+	enabled := true
+	config := &discovery.CaddyConfig{
+		Compression:  discovery.CompressionConfig{Enabled: &enabled},
+		CacheControl: "no-store",
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "app.example.com", Upstream: ":8080"},
+		{Domain: "assets.example.com", Upstream: ":8081", Options: discovery.RouteOptions{
+			CacheControl: "public, max-age=31536000, immutable",
+		}},
+		{Domain: "legacy.example.com", Upstream: ":8082", Options: discovery.RouteOptions{
+			Compression: discovery.CompressionConfig{Encodings: []string{"br"}},
+		}},
+	}
+
+	routes := renderServers(config, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	app := routes[0].Handle[0].Routes[0].Handle
+	if len(app) != 3 || app[0].Handler != "headers" || app[1].Handler != "encode" {
+		t.Fatalf("Expected headers and encode before reverse_proxy, got %+v", app)
+	}
+	if value := app[0].Response.Set["Cache-Control"]; len(value) != 1 || value[0] != "no-store" {
+		t.Errorf("Expected global Cache-Control no-store, got %v", value)
+	}
+	assets := routes[1].Handle[0].Routes[0].Handle
+	if value := assets[0].Response.Set["Cache-Control"]; len(value) != 1 || value[0] != "public, max-age=31536000, immutable" {
+		t.Errorf("Expected route Cache-Control, got %v", value)
+	}
+	// brotli is not part of a standard Caddy build, so compression is disabled for the route
+	for _, handle := range routes[2].Handle[0].Routes[0].Handle {
+		if handle.Handler == "encode" {
+			t.Errorf("Expected no encode handler for unsupported encoding")
+		}
+	}
+}
+
+func TestRenderServersUsesFallbackAndErrorPages(t *testing.T) {
+	config := &discovery.CaddyConfig{
+		Fallback: discovery.PageConfig{Status: 421, Body: "Misdirected Request"},
+		ErrorPages: discovery.ErrorPagesConfig{
+			PageConfig:  discovery.PageConfig{Body: "Upstream down"},
+			StatusCodes: []int{502},
+		},
+	}
+
+	server := renderServers(config, nil, maintenanceState{})[discovery.DefaultServerName]
+
+	fallback := server.Routes[len(server.Routes)-1].Handle[0]
+	if fallback.StatusCode != 421 || fallback.Body != "Misdirected Request" {
+		t.Errorf("Expected configured fallback, got %+v", fallback)
+	}
+	if server.Errors == nil || len(server.Errors.Routes) != 1 || server.Errors.Routes[0].Handle[0].StatusCode != 502 {
+		t.Errorf("Expected error route for 502, got %+v", server.Errors)
+	}
+}
+
+func TestRenderServersBalancesEndpointsOfADomain(t *testing.T) {
+	canaryWeight := 1
+	stableWeight := 9
+	endpoints := []provider.EndpointInfo{
+		{Domain: "app.example.com", Upstream: "10.0.0.2:8080", Options: discovery.RouteOptions{
+			Version: discovery.VersionConfig{Name: "canary", Weight: &canaryWeight, Cookie: "version"},
+		}},
+		{Domain: "other.example.com", Upstream: "10.0.0.9:8080"},
+		{Domain: "app.example.com", Upstream: "10.0.0.1:8080", Options: discovery.RouteOptions{
+			Version: discovery.VersionConfig{Name: "stable", Weight: &stableWeight, Cookie: "version"},
+		}},
+	}
+
+	routes := renderServers(&discovery.CaddyConfig{}, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	if len(routes) != 3 {
+		t.Fatalf("Expected one route per domain and the fallback route, got %d routes", len(routes))
+	}
+	subroutes := routes[0].Handle[0].Routes
+	if len(subroutes) != 3 {
+		t.Fatalf("Expected two pinned routes and the balanced route, got %d routes", len(subroutes))
+	}
+	// endpoints are sorted by upstream, so the config does not depend on the order they were discovered in
+	balanced := subroutes[2].Handle[0]
+	if balanced.Upstreams[0].Dial != "10.0.0.1:8080" || balanced.LoadBalancing.SelectionPolicy.Weights[0] != 9 {
+		t.Errorf("Expected stable upstream with weight 9 first, got %+v", balanced)
+	}
+}
+
+func TestRenderServersSkipsDomainsWithConflictingEndpointOptions(t *testing.T) {
+	endpoints := []provider.EndpointInfo{
+		{Domain: "admin.example.com", Upstream: "10.0.0.1:8080", Options: discovery.RouteOptions{
+			BasicAuth: discovery.BasicAuthConfig{Users: map[string]string{"admin": "$2a$14$hash"}},
+			Version:   discovery.VersionConfig{Name: "stable"},
+		}},
+		{Domain: "admin.example.com", Upstream: "10.0.0.2:8080", Options: discovery.RouteOptions{
+			Version: discovery.VersionConfig{Name: "canary"},
+		}},
+		{Domain: "app.example.com", Upstream: "10.0.0.3:8080", Options: discovery.RouteOptions{
+			Access:  discovery.AccessConfig{Allow: []string{"10.0.0.0/8"}},
+			Version: discovery.VersionConfig{Name: "stable"},
+		}},
+		{Domain: "app.example.com", Upstream: "10.0.0.4:8080", Options: discovery.RouteOptions{
+			Access:  discovery.AccessConfig{Allow: []string{"10.0.0.0/8"}},
+			Version: discovery.VersionConfig{Name: "canary"},
+		}},
+	}
+
+	routes := renderServers(&discovery.CaddyConfig{}, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	// the unprotected canary would expose admin.example.com without basic auth, so the domain is skipped
+	if len(routes) != 2 {
+		t.Fatalf("Expected the route of app.example.com and the fallback route, got %d routes", len(routes))
+	}
+	if host := routes[0].Match[0].Host[0]; host != "app.example.com" {
+		t.Errorf("Expected route of app.example.com, got %s", host)
+	}
+}
+
+func TestRenderServersUsesOptionsOfOldestEndpoint(t *testing.T) {
+	endpoints := []provider.EndpointInfo{
+		{Domain: "app.example.com", Upstream: "10.0.0.2:8080", Options: discovery.RouteOptions{
+			CacheControl: "no-store",
+			Version:      discovery.VersionConfig{Name: "stable"},
+		}},
+		{Domain: "app.example.com", Upstream: "10.0.0.1:8080", Options: discovery.RouteOptions{
+			CacheControl: "max-age=60",
+			Version:      discovery.VersionConfig{Name: "canary"},
+		}},
+	}
+
+	routes := renderServers(&discovery.CaddyConfig{}, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	// differences that do not weaken the protection keep the domain routed
+	if len(routes) != 2 {
+		t.Fatalf("Expected the route of app.example.com and the fallback route, got %d routes", len(routes))
+	}
+	content, err := json.Marshal(routes[0])
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(string(content), "no-store") || strings.Contains(string(content), "max-age=60") {
+		t.Errorf("Expected the cache control of the oldest endpoint, got %s", content)
+	}
+}
+
+func TestRenderServersHashesClientAddressesBehindTrustedProxies(t *testing.T) {
+	options := discovery.RouteOptions{
+		LoadBalancing: discovery.LoadBalancingConfig{Policy: discovery.LoadBalancingIPHash},
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "app.example.com", Upstream: "10.0.0.1:8080", Options: options},
+		{Domain: "app.example.com", Upstream: "10.0.0.2:8080", Options: options},
+	}
+
+	tests := map[string]struct {
+		trustedProxies []string
+		expectedPolicy string
+	}{
+		"direct clients":  {trustedProxies: nil, expectedPolicy: "ip_hash"},
+		"trusted proxies": {trustedProxies: []string{"192.168.0.0/24"}, expectedPolicy: "client_ip_hash"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := &discovery.CaddyConfig{TrustedProxies: test.trustedProxies}
+			routes := renderServers(config, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+			policy := routes[0].Handle[0].Routes[0].Handle[0].LoadBalancing.SelectionPolicy.Policy
+			if policy != test.expectedPolicy {
+				t.Errorf("Expected policy %s, got %s", test.expectedPolicy, policy)
+			}
+		})
+	}
+}
+
+func TestRenderServersIgnoresInvalidLoadBalancing(t *testing.T) {
+	options := discovery.RouteOptions{
+		LoadBalancing: discovery.LoadBalancingConfig{Policy: discovery.LoadBalancingHeader},
+	}
+	endpoints := []provider.EndpointInfo{
+		{Domain: "app.example.com", Upstream: "10.0.0.1:8080", Options: options},
+		{Domain: "app.example.com", Upstream: "10.0.0.2:8080", Options: options},
+	}
+
+	routes := renderServers(&discovery.CaddyConfig{}, endpoints, maintenanceState{})[discovery.DefaultServerName].Routes
+
+	proxy := routes[0].Handle[0].Routes[0].Handle[0]
+	if len(proxy.Upstreams) != 2 || proxy.LoadBalancing != nil {
+		t.Errorf("Expected both upstreams with Caddy's default selection, got %+v", proxy)
+	}
+}
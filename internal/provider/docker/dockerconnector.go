@@ -9,7 +9,6 @@ import (
 	eventtypes "github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
-	"github.com/jaku01/caddyservicediscovery/internal/caddy"
 	"github.com/jaku01/caddyservicediscovery/internal/provider"
 )
 
@@ -37,18 +36,8 @@ func NewDockerConnector() *Connector {
 	}
 }
 
-func (dc *Connector) GetRoutes() ([]caddy.Route, error) {
-	containers, err := dc.GetAllContainersWithActiveLabel()
-	if err != nil {
-		return nil, err
-	}
-
-	routes := make([]caddy.Route, 0, len(containers))
-	for _, container := range containers {
-		reverseProxyRoute := caddy.NewReverseProxyRoute(container.Domain, container.Upstream)
-		routes = append(routes, reverseProxyRoute)
-	}
-	return routes, nil
+func (dc *Connector) GetEndpoints() ([]provider.EndpointInfo, error) {
+	return dc.GetAllContainersWithActiveLabel()
 }
 
 func (dc *Connector) GetEventChannel() <-chan provider.LifecycleEvent {
@@ -103,6 +92,10 @@ func transformDockerEvent(rawEvent eventtypes.Message) *provider.LifecycleEvent
 		return nil
 	}
 
+	if referencesBasicAuthSecret(rawEvent.Actor.Attributes) {
+		return nil
+	}
+
 	portStr := rawEvent.Actor.Attributes[portLabel]
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
@@ -111,9 +104,11 @@ func transformDockerEvent(rawEvent eventtypes.Message) *provider.LifecycleEvent
 	}
 
 	containerInfo := provider.EndpointInfo{
+		Id:       rawEvent.Actor.ID,
 		Port:     port,
 		Domain:   rawEvent.Actor.Attributes[domainLabel],
 		Upstream: ":" + portStr,
+		Options:  provider.ParseRouteOptions(rawEvent.Actor.Attributes),
 	}
 
 	return &provider.LifecycleEvent{
@@ -131,6 +126,9 @@ func (dc *Connector) GetAllContainersWithActiveLabel() ([]provider.EndpointInfo,
 	var activeContainers []provider.EndpointInfo
 	for _, container := range containers {
 		if container.Labels[activeLabel] == "true" {
+			if referencesBasicAuthSecret(container.Labels) {
+				continue
+			}
 			port, err := strconv.Atoi(container.Labels[portLabel])
 			if err != nil {
 				slog.Error("Error converting port to int")
@@ -138,9 +136,11 @@ func (dc *Connector) GetAllContainersWithActiveLabel() ([]provider.EndpointInfo,
 			}
 
 			containerInfo := provider.EndpointInfo{
+				Id:       container.ID,
 				Port:     port,
 				Domain:   container.Labels[domainLabel],
 				Upstream: ":" + container.Labels[portLabel],
+				Options:  provider.ParseRouteOptions(container.Labels),
 			}
 
 			activeContainers = append(activeContainers, containerInfo)
@@ -149,3 +149,15 @@ func (dc *Connector) GetAllContainersWithActiveLabel() ([]provider.EndpointInfo,
 
 	return activeContainers, nil
 }
+
+// referencesBasicAuthSecret reports whether the container references a kubernetes secret for basic auth,
+// which can not be resolved on docker. These containers are skipped, so they are never routed without
+// the authentication they asked for.
+func referencesBasicAuthSecret(labels map[string]string) bool {
+	if labels[provider.BasicAuthSecretLabel] == "" {
+		return false
+	}
+	slog.Error("Skipping container with basic auth secret, secrets are only supported on kubernetes",
+		"domain", labels[domainLabel], "secret", labels[provider.BasicAuthSecretLabel])
+	return true
+}
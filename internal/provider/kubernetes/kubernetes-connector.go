@@ -3,8 +3,9 @@ package kubernetes
 import (
 	"context"
 	"fmt"
+	"log/slog"
 
-	"github.com/jaku01/caddyservicediscovery/internal/caddy"
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
 	"github.com/jaku01/caddyservicediscovery/internal/provider"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -41,34 +42,87 @@ func NewKubernetesConnector() (*Connector, error) {
 	}, nil
 }
 
-func (c *Connector) GetRoutes() ([]caddy.Route, error) {
+func (c *Connector) GetEndpoints() ([]provider.EndpointInfo, error) {
 	services, err := c.ClientSet.CoreV1().Services(metav1.NamespaceAll).List(context.TODO(), v1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	routes := make([]caddy.Route, 0, len(services.Items))
+	endpoints := make([]provider.EndpointInfo, 0, len(services.Items))
 	for _, svc := range services.Items {
-		domain := svc.Labels["domain"]
-		if domain == "" {
+		endpoint, ok := endpointFromService(&svc)
+		if !ok {
 			continue
 		}
-		if len(svc.Spec.Ports) == 0 {
+		if err = c.resolveBasicAuthSecret(&svc, &endpoint); err != nil {
+			slog.Error("Skipping service with unreadable basic auth secret", "service", svc.Name, "namespace", svc.Namespace, "error", err)
 			continue
 		}
+		endpoints = append(endpoints, endpoint)
+	}
 
-		upstream := fmt.Sprintf(
-			"%s.%s.svc.cluster.local:%d",
-			svc.Name,
-			svc.Namespace,
-			svc.Spec.Ports[0].Port,
-		)
+	return endpoints, nil
+}
 
-		route := caddy.NewReverseProxyRoute(domain, upstream)
-		routes = append(routes, route)
+// endpointFromService builds the endpoint of a service labeled with a domain
+func endpointFromService(svc *corev1.Service) (provider.EndpointInfo, bool) {
+	domain := svc.Labels["domain"]
+	if domain == "" || len(svc.Spec.Ports) == 0 {
+		// nothing to expose -> skip
+		return provider.EndpointInfo{}, false
 	}
 
-	return routes, nil
+	upstream := fmt.Sprintf(
+		"%s.%s.svc.cluster.local:%d",
+		svc.Name,
+		svc.Namespace,
+		svc.Spec.Ports[0].Port,
+	)
+
+	return provider.EndpointInfo{
+		Id:       serviceId(svc),
+		Port:     int(svc.Spec.Ports[0].Port),
+		Domain:   domain,
+		Upstream: upstream,
+		Options:  provider.ParseRouteOptions(svc.Annotations),
+	}, true
+}
+
+// serviceId identifies a service by namespace and name
+func serviceId(svc *corev1.Service) string {
+	return svc.Namespace + "/" + svc.Name
+}
+
+// resolveBasicAuthSecret adds the accounts of the basic auth secret referenced by the service to the endpoint.
+// The secret has to be in the namespace of the service and hold htpasswd lines in its users key.
+func (c *Connector) resolveBasicAuthSecret(svc *corev1.Service, endpoint *provider.EndpointInfo) error {
+	secretName := svc.Annotations[provider.BasicAuthSecretLabel]
+	if secretName == "" {
+		return nil
+	}
+
+	secret, err := c.ClientSet.CoreV1().Secrets(svc.Namespace).Get(c.ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	users, err := discovery.ParseHtpasswd(string(secret.Data["users"]))
+	if err != nil {
+		return fmt.Errorf("invalid users in secret %s: %w", secretName, err)
+	}
+	if len(users) == 0 {
+		return fmt.Errorf("no users in secret %s", secretName)
+	}
+
+	basicAuth := &endpoint.Options.BasicAuth
+	if basicAuth.Users == nil {
+		basicAuth.Users = make(map[string]string, len(users))
+	}
+	for user, hash := range users {
+		if _, ok := basicAuth.Users[user]; !ok {
+			basicAuth.Users[user] = hash
+		}
+	}
+	return nil
 }
 
 func (c *Connector) GetEventChannel() <-chan provider.LifecycleEvent {
@@ -84,6 +138,8 @@ func (c *Connector) GetEventChannel() <-chan provider.LifecycleEvent {
 
 		defer watcher.Stop()
 
+		// the watch starts with an added event for every existing service, so routed holds all routed services
+		routed := make(map[string]bool)
 		for ev := range watcher.ResultChan() {
 			select {
 			case <-c.ctx.Done():
@@ -103,31 +159,42 @@ func (c *Connector) GetEventChannel() <-chan provider.LifecycleEvent {
 				eventType = provider.StartEvent
 			case watch.Deleted:
 				eventType = provider.DieEvent
+			case watch.Modified:
+				// changed annotations, e.g. switching maintenance, update the route
+				eventType = provider.UpdateEvent
 			default:
-				// ignore other event types (Modified etc.)
+				// ignore other event types (Bookmark, Error)
 				continue
 			}
 
-			domain := svc.Labels["domain"]
-			if domain == "" || len(svc.Spec.Ports) == 0 {
-				// nothing to expose -> skip
-				continue
+			endpoint, ok := endpointFromService(svc)
+			if !ok {
+				// a routed service whose domain or ports were removed is not exposed anymore
+				if eventType != provider.UpdateEvent || !routed[serviceId(svc)] {
+					continue
+				}
+				endpoint = provider.EndpointInfo{Id: serviceId(svc)}
+				eventType = provider.DieEvent
+			}
+			// removed services are matched by their id, so only new and updated services need their secret
+			if eventType != provider.DieEvent {
+				if err = c.resolveBasicAuthSecret(svc, &endpoint); err != nil {
+					slog.Error("Removing service with unreadable basic auth secret",
+						"service", svc.Name, "namespace", svc.Namespace, "error", err)
+					if eventType == provider.StartEvent || !routed[endpoint.Id] {
+						continue
+					}
+					eventType = provider.DieEvent
+				}
 			}
-			port := int(svc.Spec.Ports[0].Port)
-
-			upstream := fmt.Sprintf(
-				"%s.%s.svc.cluster.local:%d",
-				svc.Name,
-				svc.Namespace,
-				svc.Spec.Ports[0].Port,
-			)
 
+			if eventType == provider.DieEvent {
+				delete(routed, endpoint.Id)
+			} else {
+				routed[endpoint.Id] = true
+			}
 			lifecycleEvents <- provider.LifecycleEvent{
-				ContainerInfo: provider.EndpointInfo{
-					Port:     port,
-					Domain:   domain,
-					Upstream: upstream,
-				},
+				ContainerInfo:      endpoint,
 				LifeCycleEventType: eventType,
 			}
 		}
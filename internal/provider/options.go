@@ -0,0 +1,259 @@
+package provider
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+// LabelPrefix is the prefix of all docker labels and kubernetes annotations configuring a route
+const LabelPrefix = "caddy.service.discovery."
+
+const (
+	serverLabel      = LabelPrefix + "server"
+	certificateLabel = LabelPrefix + "tls.certificate"
+
+	upstreamTLSLabel                   = LabelPrefix + "upstream.tls"
+	upstreamTLSServerNameLabel         = LabelPrefix + "upstream.tls.serverName"
+	upstreamTLSCAFileLabel             = LabelPrefix + "upstream.tls.caFile"
+	upstreamTLSCertFileLabel           = LabelPrefix + "upstream.tls.certFile"
+	upstreamTLSKeyFileLabel            = LabelPrefix + "upstream.tls.keyFile"
+	upstreamTLSInsecureSkipVerifyLabel = LabelPrefix + "upstream.tls.insecureSkipVerify"
+
+	dialTimeoutLabel           = LabelPrefix + "transport.dialTimeout"
+	responseHeaderTimeoutLabel = LabelPrefix + "transport.responseHeaderTimeout"
+	readTimeoutLabel           = LabelPrefix + "transport.readTimeout"
+	writeTimeoutLabel          = LabelPrefix + "transport.writeTimeout"
+	keepAliveLabel             = LabelPrefix + "transport.keepAlive"
+	keepAliveIdleTimeoutLabel  = LabelPrefix + "transport.keepAlive.idleTimeout"
+	maxIdleConnsPerHostLabel   = LabelPrefix + "transport.keepAlive.maxIdleConnsPerHost"
+	versionsLabel              = LabelPrefix + "transport.versions"
+	maxConnsPerHostLabel       = LabelPrefix + "transport.maxConnsPerHost"
+
+	requestHeadersLabelPrefix  = LabelPrefix + "headers.request."
+	responseHeadersLabelPrefix = LabelPrefix + "headers.response."
+
+	basicAuthUsersLabel         = LabelPrefix + "basicAuth.users"
+	basicAuthRealmLabel         = LabelPrefix + "basicAuth.realm"
+	forwardAuthLabel            = LabelPrefix + "forwardAuth"
+	forwardAuthAddressLabel     = LabelPrefix + "forwardAuth.address"
+	forwardAuthUriLabel         = LabelPrefix + "forwardAuth.uri"
+	forwardAuthCopyHeadersLabel = LabelPrefix + "forwardAuth.copyHeaders"
+
+	accessAllowLabel = LabelPrefix + "access.allow"
+	accessDenyLabel  = LabelPrefix + "access.deny"
+
+	aliasesLabel        = LabelPrefix + "aliases"
+	redirectStatusLabel = LabelPrefix + "redirectStatus"
+
+	compressionLabel              = LabelPrefix + "compression"
+	compressionEncodingsLabel     = LabelPrefix + "compression.encodings"
+	compressionMinimumLengthLabel = LabelPrefix + "compression.minimumLength"
+	compressionContentTypesLabel  = LabelPrefix + "compression.contentTypes"
+	cacheControlLabel             = LabelPrefix + "cacheControl"
+	maintenanceLabel              = LabelPrefix + "maintenance"
+
+	versionLabel       = LabelPrefix + "version"
+	weightLabel        = LabelPrefix + "weight"
+	versionHeaderLabel = LabelPrefix + "version.header"
+	versionCookieLabel = LabelPrefix + "version.cookie"
+
+	loadBalancingLabel             = LabelPrefix + "loadBalancing"
+	loadBalancingCookieNameLabel   = LabelPrefix + "loadBalancing.cookie.name"
+	loadBalancingCookieSecretLabel = LabelPrefix + "loadBalancing.cookie.secret"
+	loadBalancingHeaderLabel       = LabelPrefix + "loadBalancing.header"
+
+	// BasicAuthSecretLabel references a kubernetes secret holding htpasswd lines in its users key
+	BasicAuthSecretLabel = LabelPrefix + "basicAuth.secret"
+)
+
+// ParseRouteOptions reads the route options from docker labels or kubernetes annotations
+func ParseRouteOptions(labels map[string]string) discovery.RouteOptions {
+	return discovery.RouteOptions{
+		Server:      labels[serverLabel],
+		Certificate: labels[certificateLabel],
+		UpstreamTLS: discovery.UpstreamTLSConfig{
+			Enabled:            parseBoolLabel(labels, upstreamTLSLabel),
+			ServerName:         labels[upstreamTLSServerNameLabel],
+			CAFilePath:         labels[upstreamTLSCAFileLabel],
+			CertFilePath:       labels[upstreamTLSCertFileLabel],
+			KeyFilePath:        labels[upstreamTLSKeyFileLabel],
+			InsecureSkipVerify: parseBoolLabel(labels, upstreamTLSInsecureSkipVerifyLabel),
+		},
+		Transport: discovery.TransportConfig{
+			DialTimeout:           parseDurationLabel(labels, dialTimeoutLabel),
+			ResponseHeaderTimeout: parseDurationLabel(labels, responseHeaderTimeoutLabel),
+			ReadTimeout:           parseDurationLabel(labels, readTimeoutLabel),
+			WriteTimeout:          parseDurationLabel(labels, writeTimeoutLabel),
+			KeepAlive: discovery.KeepAliveConfig{
+				Disabled:            labels[keepAliveLabel] != "" && !parseBoolLabel(labels, keepAliveLabel),
+				IdleTimeout:         parseDurationLabel(labels, keepAliveIdleTimeoutLabel),
+				MaxIdleConnsPerHost: parseIntLabel(labels, maxIdleConnsPerHostLabel),
+			},
+			Versions:        parseListLabel(labels, versionsLabel),
+			MaxConnsPerHost: parseIntLabel(labels, maxConnsPerHostLabel),
+		},
+		Headers: discovery.HeadersConfig{
+			Request:  parseHeaderLabels(labels, requestHeadersLabelPrefix),
+			Response: parseHeaderLabels(labels, responseHeadersLabelPrefix),
+		},
+		BasicAuth: discovery.BasicAuthConfig{
+			Users: parseBasicAuthUsers(labels),
+			Realm: labels[basicAuthRealmLabel],
+		},
+		ForwardAuth: discovery.ForwardAuthConfig{
+			Enabled:     parseOptionalBoolLabel(labels, forwardAuthLabel),
+			Address:     labels[forwardAuthAddressLabel],
+			Uri:         labels[forwardAuthUriLabel],
+			CopyHeaders: parseListLabel(labels, forwardAuthCopyHeadersLabel),
+		},
+		Access: discovery.AccessConfig{
+			Allow: parseListLabel(labels, accessAllowLabel),
+			Deny:  parseListLabel(labels, accessDenyLabel),
+		},
+		Aliases:        parseListLabel(labels, aliasesLabel),
+		RedirectStatus: parseIntLabel(labels, redirectStatusLabel),
+		Compression: discovery.CompressionConfig{
+			Enabled:       parseOptionalBoolLabel(labels, compressionLabel),
+			Encodings:     parseListLabel(labels, compressionEncodingsLabel),
+			MinimumLength: parseIntLabel(labels, compressionMinimumLengthLabel),
+			ContentTypes:  parseListLabel(labels, compressionContentTypesLabel),
+		},
+		CacheControl: labels[cacheControlLabel],
+		Maintenance:  parseBoolLabel(labels, maintenanceLabel),
+		Version: discovery.VersionConfig{
+			Name:   labels[versionLabel],
+			Weight: parseOptionalIntLabel(labels, weightLabel),
+			Header: labels[versionHeaderLabel],
+			Cookie: labels[versionCookieLabel],
+		},
+		LoadBalancing: discovery.LoadBalancingConfig{
+			Policy:       labels[loadBalancingLabel],
+			CookieName:   labels[loadBalancingCookieNameLabel],
+			CookieSecret: labels[loadBalancingCookieSecretLabel],
+			Header:       labels[loadBalancingHeaderLabel],
+		},
+	}
+}
+
+// parseBasicAuthUsers reads comma separated user:hash pairs, bcrypt hashes contain neither commas nor colons.
+// Malformed entries are kept with an empty hash, so resolving the accounts fails and the route is not exposed.
+func parseBasicAuthUsers(labels map[string]string) map[string]string {
+	entries := parseListLabel(labels, basicAuthUsersLabel)
+	if len(entries) == 0 {
+		return nil
+	}
+	users := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		user, hash, _ := strings.Cut(entry, ":")
+		users[user] = hash
+	}
+	return users
+}
+
+// parseHeaderLabels reads the header operations below the prefix: "set.<name>" and "add.<name>" labels hold
+// the header value, "delete" a comma separated list of header names
+func parseHeaderLabels(labels map[string]string, prefix string) discovery.HeaderOpsConfig {
+	ops := discovery.HeaderOpsConfig{
+		Delete: parseListLabel(labels, prefix+"delete"),
+	}
+	for label, value := range labels {
+		if name, ok := strings.CutPrefix(label, prefix+"set."); ok && name != "" {
+			if ops.Set == nil {
+				ops.Set = make(map[string]string)
+			}
+			ops.Set[name] = value
+		}
+		if name, ok := strings.CutPrefix(label, prefix+"add."); ok && name != "" {
+			if ops.Add == nil {
+				ops.Add = make(map[string]string)
+			}
+			ops.Add[name] = value
+		}
+	}
+	return ops
+}
+
+// parseBoolLabel reads a boolean label, missing and invalid values are false
+func parseBoolLabel(labels map[string]string, label string) bool {
+	value, ok := labels[label]
+	if !ok {
+		return false
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Ignoring invalid boolean label", "label", label, "value", value)
+		return false
+	}
+	return parsed
+}
+
+// parseOptionalBoolLabel reads a boolean label, nil if the label is missing or invalid, so the global default applies
+func parseOptionalBoolLabel(labels map[string]string, label string) *bool {
+	value, ok := labels[label]
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Ignoring invalid boolean label", "label", label, "value", value)
+		return nil
+	}
+	return &parsed
+}
+
+// parseDurationLabel reads a duration label like "30s", missing and invalid values are zero
+func parseDurationLabel(labels map[string]string, label string) time.Duration {
+	value, ok := labels[label]
+	if !ok {
+		return 0
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("Ignoring invalid duration label", "label", label, "value", value)
+		return 0
+	}
+	return parsed
+}
+
+// parseIntLabel reads an integer label, missing and invalid values are zero
+func parseIntLabel(labels map[string]string, label string) int {
+	value, ok := labels[label]
+	if !ok {
+		return 0
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Ignoring invalid integer label", "label", label, "value", value)
+		return 0
+	}
+	return parsed
+}
+
+// parseOptionalIntLabel reads an integer label, nil if the label is missing or invalid
+func parseOptionalIntLabel(labels map[string]string, label string) *int {
+	value, ok := labels[label]
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Ignoring invalid integer label", "label", label, "value", value)
+		return nil
+	}
+	return &parsed
+}
+
+// parseListLabel reads a comma separated label, empty entries are dropped
+func parseListLabel(labels map[string]string, label string) []string {
+	var values []string
+	for _, value := range strings.Split(labels[label], ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
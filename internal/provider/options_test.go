@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jaku01/caddyservicediscovery/internal/discovery"
+)
+
+func TestParseRouteOptions(t *testing.T) {
+	enabled := true
+	disabled := false
+	weight := 0
+
+	tests := map[string]struct {
+		labels   map[string]string
+		expected discovery.RouteOptions
+	}{
+		"no labels": {
+			labels:   map[string]string{},
+			expected: discovery.RouteOptions{},
+		},
+		"server and certificate": {
+			labels: map[string]string{
+				LabelPrefix + "server":          "internal",
+				LabelPrefix + "tls.certificate": "wildcard",
+			},
+			expected: discovery.RouteOptions{Server: "internal", Certificate: "wildcard"},
+		},
+		"upstream tls": {
+			labels: map[string]string{
+				LabelPrefix + "upstream.tls":                    "true",
+				LabelPrefix + "upstream.tls.serverName":         "app.internal",
+				LabelPrefix + "upstream.tls.insecureSkipVerify": "yes",
+			},
+			expected: discovery.RouteOptions{
+				UpstreamTLS: discovery.UpstreamTLSConfig{Enabled: true, ServerName: "app.internal"},
+			},
+		},
+		"transport": {
+			labels: map[string]string{
+				LabelPrefix + "transport.dialTimeout":                   "5s",
+				LabelPrefix + "transport.readTimeout":                   "soon",
+				LabelPrefix + "transport.keepAlive":                     "false",
+				LabelPrefix + "transport.keepAlive.maxIdleConnsPerHost": "8",
+				LabelPrefix + "transport.versions":                      "1.1, 2,",
+			},
+			expected: discovery.RouteOptions{
+				Transport: discovery.TransportConfig{
+					DialTimeout: 5 * time.Second,
+					KeepAlive:   discovery.KeepAliveConfig{Disabled: true, MaxIdleConnsPerHost: 8},
+					Versions:    []string{"1.1", "2"},
+				},
+			},
+		},
+		"headers": {
+			labels: map[string]string{
+				LabelPrefix + "headers.request.set.X-Forwarded-Proto": "https",
+				LabelPrefix + "headers.request.set.":                  "ignored",
+				LabelPrefix + "headers.response.add.X-Frame-Options":  "DENY",
+				LabelPrefix + "headers.response.delete":               "Server,X-Powered-By",
+			},
+			expected: discovery.RouteOptions{
+				Headers: discovery.HeadersConfig{
+					Request: discovery.HeaderOpsConfig{Set: map[string]string{"X-Forwarded-Proto": "https"}},
+					Response: discovery.HeaderOpsConfig{
+						Add:    map[string]string{"X-Frame-Options": "DENY"},
+						Delete: []string{"Server", "X-Powered-By"},
+					},
+				},
+			},
+		},
+		// files on the discovery host can only be referenced by the configuration, not by services
+		"basic auth": {
+			labels: map[string]string{
+				LabelPrefix + "basicAuth.users": "alice:$2a$14$hash,bob",
+				LabelPrefix + "basicAuth.file":  "/etc/shadow",
+				LabelPrefix + "basicAuth.realm": "admin",
+			},
+			expected: discovery.RouteOptions{
+				BasicAuth: discovery.BasicAuthConfig{
+					Users: map[string]string{"alice": "$2a$14$hash", "bob": ""},
+					Realm: "admin",
+				},
+			},
+		},
+		"forward auth opt-out": {
+			labels: map[string]string{LabelPrefix + "forwardAuth": "false"},
+			expected: discovery.RouteOptions{
+				ForwardAuth: discovery.ForwardAuthConfig{Enabled: &disabled},
+			},
+		},
+		// invalid optional booleans are ignored, so the global default applies
+		"invalid optional booleans": {
+			labels: map[string]string{
+				LabelPrefix + "forwardAuth": "off!",
+				LabelPrefix + "compression": "maybe",
+			},
+			expected: discovery.RouteOptions{},
+		},
+		"compression and access": {
+			labels: map[string]string{
+				LabelPrefix + "compression":               "1",
+				LabelPrefix + "compression.minimumLength": "512",
+				LabelPrefix + "access.allow":              "10.0.0.0/8",
+				LabelPrefix + "access.deny":               "10.0.0.1",
+			},
+			expected: discovery.RouteOptions{
+				Compression: discovery.CompressionConfig{Enabled: &enabled, MinimumLength: 512},
+				Access:      discovery.AccessConfig{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.1"}},
+			},
+		},
+		"aliases and maintenance": {
+			labels: map[string]string{
+				LabelPrefix + "aliases":        "www.example.com, example.org",
+				LabelPrefix + "redirectStatus": "308",
+				LabelPrefix + "cacheControl":   "no-store",
+				LabelPrefix + "maintenance":    "true",
+			},
+			expected: discovery.RouteOptions{
+				Aliases:        []string{"www.example.com", "example.org"},
+				RedirectStatus: 308,
+				CacheControl:   "no-store",
+				Maintenance:    true,
+			},
+		},
+		"version": {
+			labels: map[string]string{
+				LabelPrefix + "version":        "v2",
+				LabelPrefix + "weight":         "0",
+				LabelPrefix + "version.header": "X-Version",
+			},
+			expected: discovery.RouteOptions{
+				Version: discovery.VersionConfig{Name: "v2", Weight: &weight, Header: "X-Version"},
+			},
+		},
+		"invalid weight": {
+			labels:   map[string]string{LabelPrefix + "weight": "half"},
+			expected: discovery.RouteOptions{},
+		},
+		"load balancing": {
+			labels: map[string]string{
+				LabelPrefix + "loadBalancing":               "cookie",
+				LabelPrefix + "loadBalancing.cookie.name":   "lb",
+				LabelPrefix + "loadBalancing.cookie.secret": "secret",
+			},
+			expected: discovery.RouteOptions{
+				LoadBalancing: discovery.LoadBalancingConfig{Policy: "cookie", CookieName: "lb", CookieSecret: "secret"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			options := ParseRouteOptions(test.labels)
+			if !reflect.DeepEqual(options, test.expected) {
+				t.Errorf("Expected %+v, got %+v", test.expected, options)
+			}
+		})
+	}
+}
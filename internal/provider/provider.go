@@ -1,16 +1,19 @@
 package provider
 
-import "github.com/jaku01/caddyservicediscovery/internal/caddy"
+import "github.com/jaku01/caddyservicediscovery/internal/discovery"
 
 type ServiceDiscoveryProvider interface {
-	GetRoutes() ([]caddy.Route, error)
+	GetEndpoints() ([]EndpointInfo, error)
 	GetEventChannel() <-chan LifecycleEvent
 }
 
 type EndpointInfo struct {
-	Port     int    `yaml:"port"`
-	Domain   string `yaml:"domain"`
-	Upstream string `yaml:"upstream"`
+	// Id identifies the container or service across events, so updates find the endpoint even if its domain changed
+	Id       string                 `yaml:"id,omitempty"`
+	Port     int                    `yaml:"port"`
+	Domain   string                 `yaml:"domain"`
+	Upstream string                 `yaml:"upstream"`
+	Options  discovery.RouteOptions `yaml:"options"`
 }
 
 type LifecycleEvent struct {
@@ -23,6 +26,8 @@ type EventType int
 const (
 	StartEvent = iota
 	DieEvent
+	// UpdateEvent replaces the options of a running endpoint, e.g. after its annotations changed
+	UpdateEvent
 )
 
 func (e EventType) String() string {
@@ -31,6 +36,8 @@ func (e EventType) String() string {
 		return "StartEvent"
 	case DieEvent:
 		return "DieEvent"
+	case UpdateEvent:
+		return "UpdateEvent"
 	default:
 		return "unknown"
 	}